@@ -0,0 +1,133 @@
+package pdfstraighten
+
+import (
+	"context"
+	"math"
+
+	"github.com/bmharper/textorient"
+)
+
+// PageAnalysis describes what straightening would do to a page
+type PageAnalysis struct {
+	Page        int     // Zero-based page index
+	Angle       float64 // Skew correction in degrees (before orientation)
+	Confidence  float64 // Confidence of Angle (see PageAngle)
+	Orientation int     // Clockwise rotation in degrees that MakeUpright would apply: 0, 90, 180, or 270
+	Flipped180  bool    // True if Check180 would turn the page upside down
+	Blank       bool    // True if RemoveBlankPages would drop the page
+}
+
+// Report what straightening would do to a page, without rotating its image, encoding any images, or building a PDF.
+// The orientation is decided by the votes of the regions of the page before it is deskewed, so on a page
+// whose text is hard to read, it can differ from the orientation that straightening picks.
+// Pages that Straighten would skip (see StraightenScannedOnly) have nothing but their Page.
+func (d *Document) AnalyzePage(orient *textorient.Orient, page int, maxAngle float64, include90Degrees bool) (PageAnalysis, error) {
+	if err := d.validatePages([]int{page}); err != nil {
+		return PageAnalysis{}, err
+	}
+	analysis := make([]PageAnalysis, 1)
+	err := d.analyzePages(context.Background(), orient, []int{page}, maxAngle, include90Degrees, analysis)
+	return analysis[0], err
+}
+
+// Run AnalyzePage on every page of the document
+func (d *Document) Analyze(orient *textorient.Orient, maxAngle float64, include90Degrees bool) ([]PageAnalysis, error) {
+	analysis := make([]PageAnalysis, d.NumPages)
+	if err := d.analyzePages(context.Background(), orient, d.allPages(), maxAngle, include90Degrees, analysis); err != nil {
+		return nil, err
+	}
+	return analysis, nil
+}
+
+// Analyze pages, and store the results in analysis, which is aligned with pages
+func (d *Document) analyzePages(ctx context.Context, orient *textorient.Orient, pages []int, maxAngle float64, include90Degrees bool, analysis []PageAnalysis) error {
+	for i, page := range pages {
+		analysis[i].Page = page
+	}
+	unscanned, err := d.unscannedPages()
+	if err != nil {
+		return err
+	}
+	return d.forEachPage(ctx, pages, func(i, page int) error {
+		if unscanned != nil && unscanned[page] {
+			return nil
+		}
+		a, err := withPageTimeout(d, page, func() (PageAnalysis, error) {
+			return d.analyzeOnePage(orient, page, maxAngle, include90Degrees)
+		})
+		if err != nil {
+			return err
+		}
+		analysis[i] = a
+		return nil
+	})
+}
+
+// Analyze a single page, for analyzePages.
+// This follows straightenPage and transformImage, but only measures the page, and never rotates it by anything
+// other than a quarter turn.
+func (d *Document) analyzeOnePage(orient *textorient.Orient, page int, maxAngle float64, include90Degrees bool) (PageAnalysis, error) {
+	_, img, err := d.getCachedImageOnPage(page)
+	if err != nil {
+		return PageAnalysis{}, err
+	}
+	a := PageAnalysis{Page: page}
+	if d.RemoveBlankPages && d.isBlankImage(img) {
+		a.Blank = true
+		return a, nil
+	}
+	var angle float64
+	if override, ok := d.AngleOverrides[page]; ok {
+		angle = override
+		if math.IsNaN(override) {
+			angle = 0
+			orient = nil
+		}
+	} else {
+		angle, a.Confidence = d.getImageAngle(page, img, maxAngle, include90Degrees)
+	}
+	deskewOnly := d.DeskewOnly || orient == nil
+	angle = d.correctionAngle(angle, deskewOnly)
+	if d.OrientFirst && !deskewOnly {
+		// The orientation takes care of any quarter turn
+		angle = skewOnly(angle)
+	}
+	a.Angle = angle
+	if deskewOnly {
+		return a, nil
+	}
+	// The skew barely matters to textorient, but a quarter turn does
+	votes, err := orientationVotes(orient, rotateOrthogonal(img, -(angle-skewOnly(angle))))
+	if err != nil {
+		return PageAnalysis{}, err
+	}
+	a.Orientation, a.Flipped180 = d.orientationFromVotes(votes)
+	return a, nil
+}
+
+// Returns the clockwise rotation that makeUpright would apply to an image whose regions voted votes (see orientationVotes),
+// and whether orientImage would then flip it 180 degrees. This is the majority vote, subject to DisableOrient90,
+// DisableOrient180, OrientMinConfidence, and Check180.
+func (d *Document) orientationFromVotes(votes [4]int) (int, bool) {
+	total := votes[0] + votes[1] + votes[2] + votes[3]
+	if total == 0 {
+		return 0, false
+	}
+	o := textorient.Angle0
+	for v := range votes {
+		if votes[v] > votes[o] {
+			o = v
+		}
+	}
+	if (d.DisableOrient90 && (o == textorient.Angle90 || o == textorient.Angle270)) || (d.DisableOrient180 && o == textorient.Angle180) {
+		o = textorient.Angle0
+	}
+	if d.OrientMinConfidence > 0 && float64(votes[o])/float64(total) < d.OrientMinConfidence {
+		o = textorient.Angle0
+	}
+	// Each vote is a clockwise rotation in quarter turns, so once the page is upright, the votes for a page
+	// that is still upside down are those that were 2 quarter turns beyond o
+	flipped := d.Check180 && !d.DisableOrient180 && float64(votes[(o+2)%4]) >= check180Agreement*float64(total)
+	// These directions match makeUpright
+	return (4 - o) % 4 * 90, flipped
+}
@@ -0,0 +1,70 @@
+package pdfstraighten
+
+import (
+	"github.com/bmharper/cimg/v2"
+)
+
+// A row or column at the edge of a page is part of a scanner border if at least this fraction of its pixels are dark
+const autoCropBorderFraction = 0.6
+
+// Crop away the dark borders around img, which are left by a scanner lid that didn't cover the whole glass.
+// Rows and columns are trimmed from each edge for as long as they are mostly dark, and then padding pixels
+// are added back on every side. Returns img itself if there is nothing to crop, or if the whole image is dark.
+func autoCrop(img *cimg.Image, padding int) *cimg.Image {
+	gray := img
+	if img.NChan() != 1 {
+		gray = img.ToGray()
+	}
+	x1, y1, x2, y2 := 0, 0, gray.Width, gray.Height
+
+	isBorderColumn := func(x int) bool {
+		dark := 0
+		for y := y1; y < y2; y++ {
+			if gray.Pixels[y*gray.Stride+x] < bilevelThreshold {
+				dark++
+			}
+		}
+		return float64(dark) >= autoCropBorderFraction*float64(y2-y1)
+	}
+	isBorderRow := func(y int) bool {
+		dark := 0
+		for _, v := range gray.Pixels[y*gray.Stride+x1 : y*gray.Stride+x2] {
+			if v < bilevelThreshold {
+				dark++
+			}
+		}
+		return float64(dark) >= autoCropBorderFraction*float64(x2-x1)
+	}
+
+	// Once the side borders are gone, the top and bottom borders stand out more clearly, and vice versa,
+	// so we make a second pass over all four edges.
+	for range 2 {
+		for x1 < x2 && isBorderColumn(x1) {
+			x1++
+		}
+		for x2 > x1 && isBorderColumn(x2-1) {
+			x2--
+		}
+		for y1 < y2 && isBorderRow(y1) {
+			y1++
+		}
+		for y2 > y1 && isBorderRow(y2-1) {
+			y2--
+		}
+	}
+	if x1 >= x2 || y1 >= y2 {
+		return img
+	}
+
+	x1 = max(x1-padding, 0)
+	y1 = max(y1-padding, 0)
+	x2 = min(x2+padding, img.Width)
+	y2 = min(y2+padding, img.Height)
+	if x1 == 0 && y1 == 0 && x2 == img.Width && y2 == img.Height {
+		return img
+	}
+	// ReferenceCrop would be cheaper, but it slices past the end of the pixels when y2 is the bottom row
+	cropped := cimg.NewImage(x2-x1, y2-y1, img.Format)
+	cropped.CopyImageRect(img, x1, y1, x2, y2, 0, 0)
+	return cropped
+}
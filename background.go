@@ -0,0 +1,112 @@
+package pdfstraighten
+
+import (
+	"image/color"
+	"math"
+
+	"github.com/bmharper/cimg/v2"
+)
+
+// Returns the bytes of a single pixel of color c, in the given format.
+// The second return value is false for formats that we don't know how to fill.
+func pixelBytes(format cimg.PixelFormat, c color.Color) ([]byte, bool) {
+	r, g, b, _ := c.RGBA()
+	r8, g8, b8 := uint8(r>>8), uint8(g>>8), uint8(b>>8)
+	switch format {
+	case cimg.PixelFormatGRAY:
+		return []byte{color.GrayModel.Convert(c).(color.Gray).Y}, true
+	case cimg.PixelFormatRGB:
+		return []byte{r8, g8, b8}, true
+	case cimg.PixelFormatBGR:
+		return []byte{b8, g8, r8}, true
+	case cimg.PixelFormatRGBX, cimg.PixelFormatRGBA:
+		return []byte{r8, g8, b8, 255}, true
+	case cimg.PixelFormatBGRX, cimg.PixelFormatBGRA:
+		return []byte{b8, g8, r8, 255}, true
+	case cimg.PixelFormatXBGR, cimg.PixelFormatABGR:
+		return []byte{255, b8, g8, r8}, true
+	case cimg.PixelFormatXRGB, cimg.PixelFormatARGB:
+		return []byte{255, r8, g8, b8}, true
+	}
+	return nil, false
+}
+
+// After cimg.Rotate(src, dst, angleRadians), fill every pixel of dst that came from outside of src with color c.
+// cimg clamps to the edge of the source image, which smears the border of the scan into the corners.
+// This uses the same inverse mapping as cimg's bilinear rotation.
+func fillOutsideRotation(srcWidth, srcHeight int, dst *cimg.Image, angleRadians float64, c color.Color) {
+	fill, ok := pixelBytes(dst.Format, c)
+	if !ok {
+		return
+	}
+	cosA := math.Cos(angleRadians)
+	sinA := math.Sin(angleRadians)
+	cxIn := float64(srcWidth-1) / 2
+	cyIn := float64(srcHeight-1) / 2
+	cxOut := float64(dst.Width-1) / 2
+	cyOut := float64(dst.Height-1) / 2
+	nchan := dst.NChan()
+
+	for y := 0; y < dst.Height; y++ {
+		yRel := float64(y) - cyOut
+		// srcX = xRel*cos + yRel*sin + cxIn, srcY = -xRel*sin + yRel*cos + cyIn
+		// Each of these is linear in xRel, so the range of x that lands inside the source is an interval.
+		lo, hi := math.Inf(-1), math.Inf(1)
+		lo, hi = clipLinear(lo, hi, cosA, yRel*sinA+cxIn, float64(srcWidth)-0.5)
+		lo, hi = clipLinear(lo, hi, -sinA, yRel*cosA+cyIn, float64(srcHeight)-0.5)
+		x1, x2 := dst.Width, dst.Width
+		if lo <= hi {
+			x1 = max(0, min(dst.Width, int(math.Ceil(lo+cxOut))))
+			x2 = max(x1, min(dst.Width, int(math.Floor(hi+cxOut))+1))
+		}
+		row := dst.Pixels[y*dst.Stride:]
+		for x := 0; x < x1; x++ {
+			copy(row[x*nchan:], fill)
+		}
+		for x := x2; x < dst.Width; x++ {
+			copy(row[x*nchan:], fill)
+		}
+	}
+}
+
+// Intersect [lo, hi] with the range of t for which -0.5 <= k*t + m <= limit
+func clipLinear(lo, hi, k, m, limit float64) (float64, float64) {
+	const minV = -0.5
+	if math.Abs(k) < 1e-12 {
+		if m < minV || m > limit {
+			return math.Inf(1), math.Inf(-1) // empty
+		}
+		return lo, hi
+	}
+	a := (minV - m) / k
+	b := (limit - m) / k
+	if a > b {
+		a, b = b, a
+	}
+	return max(lo, a), min(hi, b)
+}
+
+// Returns true if format has an alpha channel
+func hasAlpha(format cimg.PixelFormat) bool {
+	switch format {
+	case cimg.PixelFormatRGBA, cimg.PixelFormatBGRA, cimg.PixelFormatABGR, cimg.PixelFormatARGB:
+		return true
+	}
+	return false
+}
+
+// If img has an alpha channel, return an opaque copy of it composited over white (as RGB, if img is RGBA).
+// Otherwise, return img itself. A scan with transparent margins would otherwise turn black in those margins,
+// both when measuring its angle, and when it is encoded as a JPEG, which has no alpha channel.
+func flattenAlpha(img *cimg.Image) *cimg.Image {
+	if !hasAlpha(img.Format) {
+		return img
+	}
+	flat := img.Clone()
+	flat.Matte(255, 255, 255)
+	if flat.Format == cimg.PixelFormatRGBA {
+		return flat.ToRGB()
+	}
+	// ToRGB doesn't reorder channels, so other layouts keep their (now opaque) alpha channel
+	return flat
+}
@@ -0,0 +1,63 @@
+package pdfstraighten
+
+import (
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/bmharper/cimg/v2"
+)
+
+func TestFillOutsideRotation(t *testing.T) {
+	cases := []struct {
+		name      string
+		srcWidth  int
+		srcHeight int
+		dstWidth  int
+		dstHeight int
+		angle     float64
+		filled    func(x, y int) bool
+	}{
+		{
+			name:     "expanded canvas has a border",
+			srcWidth: 4, srcHeight: 4, dstWidth: 6, dstHeight: 6,
+			filled: func(x, y int) bool { return x == 0 || y == 0 || x == 5 || y == 5 },
+		},
+		{
+			name:     "half turn covers the whole canvas",
+			srcWidth: 4, srcHeight: 3, dstWidth: 4, dstHeight: 3, angle: math.Pi,
+			filled: func(x, y int) bool { return false },
+		},
+		{
+			name:     "quarter turn of a wide image into a wide canvas leaves the sides uncovered",
+			srcWidth: 6, srcHeight: 2, dstWidth: 6, dstHeight: 2, angle: math.Pi / 2,
+			filled: func(x, y int) bool { return x < 2 || x > 3 },
+		},
+	}
+	for _, c := range cases {
+		dst := cimg.NewImage(c.dstWidth, c.dstHeight, cimg.PixelFormatGRAY)
+		fillOutsideRotation(c.srcWidth, c.srcHeight, dst, c.angle, color.White)
+		for y := range dst.Height {
+			for x := range dst.Width {
+				want := byte(0)
+				if c.filled(x, y) {
+					want = 255
+				}
+				if got := dst.Pixels[y*dst.Stride+x]; got != want {
+					t.Errorf("%v: pixel (%v, %v) is %v, expected %v", c.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestFillOutsideRotationRGB(t *testing.T) {
+	dst := cimg.NewImage(3, 1, cimg.PixelFormatRGB)
+	fillOutsideRotation(1, 1, dst, 0, color.RGBA{R: 10, G: 20, B: 30, A: 255})
+	want := []byte{10, 20, 30, 0, 0, 0, 10, 20, 30}
+	for i, b := range want {
+		if dst.Pixels[i] != b {
+			t.Fatalf("pixels are %v, expected %v", dst.Pixels, want)
+		}
+	}
+}
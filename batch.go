@@ -0,0 +1,107 @@
+package pdfstraighten
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmharper/textorient"
+)
+
+// FileStatus is the outcome of processing one file in ProcessDirectory
+type FileStatus int
+
+const (
+	FileStraightened FileStatus = iota // The straightened document was written to Output
+	FileNotScanned                     // The document is not scanned, so nothing was written
+	FileFailed                         // Processing failed, and Err says why
+)
+
+func (s FileStatus) String() string {
+	switch s {
+	case FileStraightened:
+		return "straightened"
+	case FileNotScanned:
+		return "not scanned"
+	case FileFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("FileStatus(%d)", int(s))
+}
+
+// FileReport describes the processing of one file in ProcessDirectory
+type FileReport struct {
+	Input  string // Path of the source PDF
+	Output string // Path of the straightened PDF, which only exists if Status is FileStraightened
+	Status FileStatus
+	Err    error // Why the file failed (or for FileNotScanned, why it is not scanned)
+}
+
+// Report is the outcome of ProcessDirectory, with one entry per PDF file that was found
+type Report struct {
+	Files []FileReport
+}
+
+// Returns the number of files with the given status
+func (r *Report) Count(status FileStatus) int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Straighten every PDF file under inputDir (recursively) with StraightenFile, and write the results to the same
+// relative paths under outputDir. A file that fails, or is not scanned, doesn't stop the others: it is recorded in
+// the Report, and processing moves on. The error is only for failures that affect the whole directory, such as
+// inputDir being unreadable. If opts.Orient is nil, one Orient is created and shared by all of the files.
+func ProcessDirectory(inputDir, outputDir string, opts Options) (Report, error) {
+	report := Report{}
+	var inputs []string
+	err := filepath.WalkDir(inputDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && strings.ToLower(filepath.Ext(path)) == ".pdf" {
+			inputs = append(inputs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if opts.Orient == nil && len(inputs) != 0 {
+		if opts.Orient, err = textorient.NewOrient(); err != nil {
+			return report, err
+		}
+		defer opts.Orient.Close()
+	}
+
+	for _, input := range inputs {
+		rel, err := filepath.Rel(inputDir, input)
+		if err != nil {
+			return report, err
+		}
+		file := FileReport{Input: input, Output: filepath.Join(outputDir, rel)}
+		if err = os.MkdirAll(filepath.Dir(file.Output), 0755); err == nil {
+			err = StraightenFile(input, file.Output, opts)
+		}
+		switch {
+		case err == nil:
+			file.Status = FileStraightened
+		case errors.Is(err, ErrNotScanned):
+			file.Status = FileNotScanned
+			file.Err = err
+		default:
+			file.Status = FileFailed
+			file.Err = err
+		}
+		report.Files = append(report.Files, file)
+	}
+	return report, nil
+}
@@ -0,0 +1,172 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/bmharper/cimg/v2"
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/filter"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Gray level (0..255) at or above which a pixel becomes white, when converting to FormatBilevel
+const bilevelThreshold = 128
+
+// Returns true if the image is compressed with one of the bilevel (fax) codecs
+func isBilevelFilter(filters string) bool {
+	return hasFilter(filters, filter.CCITTFax) || hasFilter(filters, filter.JBIG2)
+}
+
+// Returns true if the comma separated list of filters of an image includes name
+func hasFilter(filters, name string) bool {
+	return slices.Contains(strings.Split(filters, ","), name)
+}
+
+// pdfcpu can't decode JBIG2, or CCITT Group 3 2-D images, and we can't decode JPEG 2000, but go-fitz (MuPDF) can.
+// If the page holds such an image, render the page at the image's resolution (in grayscale, for a bilevel image).
+// Otherwise, fall back to renderFallbackPage.
+func (d *Document) renderUndecodableImageOnPage(pageIdx int, cause error) ([]byte, *cimg.Image, error) {
+	pageName := fmt.Sprintf("%d", pageIdx+1)
+	var images []map[int]model.Image
+	err := d.withReader(func(r io.ReadSeeker) (err error) {
+		images, err = pdfapi.Images(r, []string{pageName}, d.pdfcpuConfig())
+		return
+	})
+	if err != nil || len(images) != 1 {
+		return d.renderFallbackPage(pageIdx, cause)
+	}
+	for _, img := range images[0] {
+		bilevel := isBilevelFilter(img.Filter)
+		if !bilevel && !hasFilter(img.Filter, filter.JPX) {
+			continue
+		}
+		dpi, err := d.imageDPI(pageIdx, img.Width, img.Height)
+		if err != nil {
+			return nil, nil, err
+		}
+		if dpi == 0 {
+			dpi = 300
+		}
+		_, rendered, err := d.renderPage(pageIdx, min(dpi, maxRenderDPI))
+		if err != nil {
+			return nil, nil, err
+		}
+		if bilevel {
+			return nil, rendered.ToGray(), nil
+		}
+		return nil, rendered, nil
+	}
+	return d.renderFallbackPage(pageIdx, cause)
+}
+
+// Threshold img to black and white, and encode it as a 1-bit PNG
+func encodeBilevelPNG(img *cimg.Image) ([]byte, error) {
+	gray := img
+	if img.NChan() != 1 {
+		gray = img.ToGray()
+	}
+	bw := image.NewPaletted(image.Rect(0, 0, gray.Width, gray.Height), color.Palette{color.Black, color.White})
+	for y := 0; y < gray.Height; y++ {
+		src := gray.Pixels[y*gray.Stride : y*gray.Stride+gray.Width]
+		dst := bw.Pix[y*bw.Stride : y*bw.Stride+gray.Width]
+		for x, v := range src {
+			if v >= bilevelThreshold {
+				dst[x] = 1
+			}
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, bw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Returns true if raw is a PNG with a two color palette, such as one produced by encodeBilevelPNG
+func isBilevelPNG(raw []byte) bool {
+	if sniffFormat(raw) != imagePNG {
+		return false
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return false
+	}
+	palette, ok := cfg.ColorModel.(color.Palette)
+	return ok && len(palette) == 2
+}
+
+// pdfcpu expands every paletted image to 24-bit RGB. If the image of the page is bilevel, then
+// replace the page's image XObject with a 1-bit image, which is about 24 times smaller before compression.
+// pageRef is a page produced by pdfcpu.NewPageForImage.
+func packBilevelImage(xRefTable *model.XRefTable, pageRef *types.IndirectRef, raw []byte) error {
+	if !isBilevelPNG(raw) {
+		return nil
+	}
+	decoded, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	bw, ok := decoded.(*image.Paletted)
+	if !ok {
+		return nil
+	}
+	// Bit value of each palette entry, in DeviceGray, where 1 is white
+	var bit [2]byte
+	for i, c := range bw.Palette {
+		if gray := color.GrayModel.Convert(c).(color.Gray); gray.Y >= bilevelThreshold {
+			bit[i] = 1
+		}
+	}
+	w := bw.Rect.Dx()
+	h := bw.Rect.Dy()
+	rowBytes := (w + 7) / 8
+	buf := make([]byte, rowBytes*h)
+	for y := 0; y < h; y++ {
+		row := buf[y*rowBytes : (y+1)*rowBytes]
+		for x, idx := range bw.Pix[y*bw.Stride : y*bw.Stride+w] {
+			row[x/8] |= bit[idx&1] << (7 - uint(x%8))
+		}
+	}
+
+	pageDict, err := xRefTable.DereferenceDict(*pageRef)
+	if err != nil {
+		return err
+	}
+	resources, err := xRefTable.DereferenceDict(pageDict["Resources"])
+	if err != nil || resources == nil {
+		return err
+	}
+	xobjects, err := xRefTable.DereferenceDict(resources["XObject"])
+	if err != nil || xobjects == nil {
+		return err
+	}
+	imgRef := xobjects.IndirectRefEntry("Im0")
+	entry, ok := xRefTable.FindTableEntryForIndRef(imgRef)
+	if !ok {
+		return fmt.Errorf("Image XObject of new page not found")
+	}
+
+	sd, err := xRefTable.NewStreamDictForBuf(buf)
+	if err != nil {
+		return err
+	}
+	sd.InsertName("Type", "XObject")
+	sd.InsertName("Subtype", "Image")
+	sd.InsertInt("Width", w)
+	sd.InsertInt("Height", h)
+	sd.InsertInt("BitsPerComponent", 1)
+	sd.InsertName("ColorSpace", model.DeviceGrayCS)
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+	entry.Object = *sd
+	return nil
+}
@@ -0,0 +1,55 @@
+package pdfstraighten
+
+import (
+	"math"
+
+	"github.com/bmharper/docangle"
+)
+
+// Parameters of Sauvola's threshold. k is lower than the usual 0.5, because faint scans have little contrast
+// to begin with, and a high k would erase their text.
+const (
+	sauvolaK     = 0.2
+	sauvolaRange = 128 // Dynamic range of the standard deviation
+)
+
+// Binarize img in place with Sauvola's adaptive threshold, so that faint text becomes solid black,
+// and uneven paper becomes solid white. window is the side of the square neighbourhood of each pixel, in pixels.
+func binarizeSauvola(img *docangle.Image, window int) {
+	width, height := img.Width, img.Height
+	if width == 0 || height == 0 || window < 1 {
+		return
+	}
+	// Integral images of the pixels and of their squares, with an extra row and column of zeros
+	stride := width + 1
+	sum := make([]float64, stride*(height+1))
+	sumSq := make([]float64, stride*(height+1))
+	for y := range height {
+		rowSum, rowSumSq := 0.0, 0.0
+		for x := range width {
+			v := float64(img.Pixels[y*width+x])
+			rowSum += v
+			rowSumSq += v * v
+			sum[(y+1)*stride+x+1] = sum[y*stride+x+1] + rowSum
+			sumSq[(y+1)*stride+x+1] = sumSq[y*stride+x+1] + rowSumSq
+		}
+	}
+	half := window / 2
+	for y := range height {
+		y1, y2 := max(0, y-half), min(height, y+half+1)
+		for x := range width {
+			x1, x2 := max(0, x-half), min(width, x+half+1)
+			n := float64((x2 - x1) * (y2 - y1))
+			s := sum[y2*stride+x2] - sum[y1*stride+x2] - sum[y2*stride+x1] + sum[y1*stride+x1]
+			sq := sumSq[y2*stride+x2] - sumSq[y1*stride+x2] - sumSq[y2*stride+x1] + sumSq[y1*stride+x1]
+			mean := s / n
+			stdDev := math.Sqrt(max(0, sq/n-mean*mean))
+			threshold := mean * (1 + sauvolaK*(stdDev/sauvolaRange-1))
+			if float64(img.Pixels[y*width+x]) > threshold {
+				img.Pixels[y*width+x] = 255
+			} else {
+				img.Pixels[y*width+x] = 0
+			}
+		}
+	}
+}
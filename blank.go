@@ -0,0 +1,98 @@
+package pdfstraighten
+
+import (
+	"github.com/bmharper/cimg/v2"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Fraction of the width and height of a page, on each side, that is ignored by blank page detection
+const blankPageMargin = 0.05
+
+// Returns true if the page at the zero-based index is blank, according to BlankPageThreshold
+func (d *Document) IsBlankPage(page int) (bool, error) {
+	if err := d.validatePages([]int{page}); err != nil {
+		return false, err
+	}
+	_, img, err := d.getCachedImageOnPage(page)
+	if err != nil {
+		return false, err
+	}
+	return d.isBlankImage(img), nil
+}
+
+func (d *Document) isBlankImage(img *cimg.Image) bool {
+	return whiteRatio(img) >= d.BlankPageThreshold
+}
+
+// Returns the fraction of pixels inside the margins of img that are white, after thresholding
+func whiteRatio(img *cimg.Image) float64 {
+	gray := img
+	if img.NChan() != 1 {
+		gray = img.ToGray()
+	}
+	padX := int(float64(gray.Width) * blankPageMargin)
+	padY := int(float64(gray.Height) * blankPageMargin)
+	white := 0
+	total := 0
+	for y := padY; y < gray.Height-padY; y++ {
+		row := gray.Pixels[y*gray.Stride+padX : y*gray.Stride+gray.Width-padX]
+		for _, v := range row {
+			if v >= bilevelThreshold {
+				white++
+			}
+		}
+		total += len(row)
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(white) / float64(total)
+}
+
+func countBlank(pages []straightenedPage) int {
+	n := 0
+	for _, p := range pages {
+		if p.blank {
+			n++
+		}
+	}
+	return n
+}
+
+// Remove a page (one-based) from the page tree of ctx
+func removePage(ctx *model.Context, pageNr int) error {
+	pageDict, pageRef, _, err := ctx.PageDict(pageNr, false)
+	if err != nil {
+		return err
+	}
+	parentRef := pageDict.IndirectRefEntry("Parent")
+	if parentRef == nil {
+		return nil
+	}
+	parent, err := ctx.DereferenceDict(*parentRef)
+	if err != nil {
+		return err
+	}
+	kids := types.Array{}
+	for _, kid := range parent.ArrayEntry("Kids") {
+		if ir, ok := kid.(types.IndirectRef); ok && ir.ObjectNumber == pageRef.ObjectNumber {
+			continue
+		}
+		kids = append(kids, kid)
+	}
+	parent.Update("Kids", kids)
+	// Every ancestor of the page counts it
+	for ref := parentRef; ref != nil; {
+		node, err := ctx.DereferenceDict(*ref)
+		if err != nil || node == nil {
+			return err
+		}
+		if count := node.IntEntry("Count"); count != nil {
+			node.Update("Count", types.Integer(*count-1))
+		}
+		ref = node.IndirectRefEntry("Parent")
+	}
+	ctx.PageCount--
+	return nil
+}
@@ -0,0 +1,68 @@
+package pdfstraighten
+
+import (
+	"fmt"
+
+	"github.com/bmharper/textorient"
+)
+
+// Lowest JPEG quality that StraightenWithSizeBudget will try. Below this, text becomes blotchy.
+const minBudgetQuality = 20
+
+// Same as Straighten, but picks the highest JPEG quality (up to OutputQuality) at which the output PDF is no
+// larger than maxBytes, by a binary search over the quality. Each step of the search straightens the document
+// again, so set ImageCacheSize to avoid decoding every page each time. Pages that pass through untouched are the
+// same size at any quality. Only FormatJPEG output has a quality, so other formats are straightened just once.
+// If the PDF doesn't fit even at the lowest quality, the smallest PDF is returned, along with an error that
+// satisfies errors.Is(err, ErrSizeBudgetExceeded).
+func (d *Document) StraightenWithSizeBudget(orient *textorient.Orient, pageAngles []float64, maxBytes int) ([]byte, error) {
+	originalQuality := d.OutputQuality
+	build := func(quality int) ([]byte, error) {
+		// The copy shares the source, and the image cache, of d
+		withQuality := *d
+		withQuality.OutputQuality = quality
+		pdf, err := withQuality.Straighten(orient, pageAngles)
+		if err == nil {
+			d.verbose("quality %v: %v bytes\n", quality, len(pdf))
+		}
+		return pdf, err
+	}
+
+	smallest, err := build(originalQuality)
+	if err != nil || len(smallest) <= maxBytes || d.OutputFormat != FormatJPEG {
+		return checkSizeBudget(smallest, maxBytes, err)
+	}
+	var best []byte
+	lo, hi := minBudgetQuality, originalQuality-1
+	for lo <= hi {
+		quality := (lo + hi) / 2
+		pdf, err := build(quality)
+		if err != nil {
+			return nil, err
+		}
+		if len(pdf) <= maxBytes {
+			best = pdf
+			lo = quality + 1
+		} else {
+			hi = quality - 1
+		}
+		if len(pdf) < len(smallest) {
+			smallest = pdf
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	return checkSizeBudget(smallest, maxBytes, nil)
+}
+
+// Returns pdf, and an error if it is larger than maxBytes
+func checkSizeBudget(pdf []byte, maxBytes int, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(pdf) > maxBytes {
+		return pdf, fmt.Errorf("%w (%v bytes, budget %v bytes)", ErrSizeBudgetExceeded, len(pdf), maxBytes)
+	}
+	return pdf, nil
+}
@@ -0,0 +1,110 @@
+package pdfstraighten
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/bmharper/cimg/v2"
+)
+
+// The settings that affect the decoded image of a page are part of the cache key,
+// so that changing them between calls doesn't return a stale image.
+type imageCacheKey struct {
+	page                   int
+	applyPageRotation      bool
+	applyExifOrientation   bool
+	compositeImages        bool
+	concatenateImageStrips bool
+	renderFallback         bool
+	renderDPI              int
+	maxDecodedPixels       int
+	downscaleOversized     bool
+}
+
+type imageCacheEntry struct {
+	key imageCacheKey
+	raw []byte
+	img *cimg.Image
+}
+
+// Least-recently-used cache of decoded page images.
+// Cached images are shared between callers, so they must never be modified in place.
+type imageCache struct {
+	lock    sync.Mutex
+	entries map[imageCacheKey]*list.Element
+	order   list.List // Front is most recently used
+}
+
+func (c *imageCache) get(key imageCacheKey) ([]byte, *cimg.Image, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	c.order.MoveToFront(el)
+	entry := el.Value.(*imageCacheEntry)
+	return entry.raw, entry.img, true
+}
+
+func (c *imageCache) put(key imageCacheKey, raw []byte, img *cimg.Image, maxSize int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.entries == nil {
+		c.entries = map[imageCacheKey]*list.Element{}
+	}
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&imageCacheEntry{key: key, raw: raw, img: img})
+	for c.order.Len() > maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*imageCacheEntry).key)
+	}
+}
+
+func (c *imageCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = nil
+	c.order.Init()
+}
+
+// Release the memory of all cached page images
+func (d *Document) ClearImageCache() {
+	d.imageCache.clear()
+}
+
+// Returns the image on the page, from the cache if possible
+func (d *Document) getCachedImageOnPage(pageIdx int) ([]byte, *cimg.Image, error) {
+	if d.ImageCacheSize <= 0 {
+		return d.getImageOnPage(pageIdx)
+	}
+	key := d.cacheKey(pageIdx)
+	if raw, img, ok := d.imageCache.get(key); ok {
+		return raw, img, nil
+	}
+	raw, img, err := d.getImageOnPage(pageIdx)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.imageCache.put(key, raw, img, d.ImageCacheSize)
+	return raw, img, nil
+}
+
+// Returns the key of the image on the page in the image cache, under the current settings
+func (d *Document) cacheKey(pageIdx int) imageCacheKey {
+	return imageCacheKey{
+		page:                   pageIdx,
+		applyPageRotation:      d.ApplyPageRotation,
+		applyExifOrientation:   d.ApplyExifOrientation,
+		compositeImages:        d.CompositeImages,
+		concatenateImageStrips: d.ConcatenateImageStrips,
+		renderFallback:         d.RenderFallback,
+		renderDPI:              d.RenderDPI,
+		maxDecodedPixels:       d.MaxDecodedPixels,
+		downscaleOversized:     d.DownscaleOversizedImages,
+	}
+}
@@ -0,0 +1,62 @@
+package pdfstraighten
+
+import (
+	"testing"
+
+	"github.com/bmharper/cimg/v2"
+)
+
+func TestCacheKeyTracksImageSettings(t *testing.T) {
+	changes := map[string]func(d *Document){
+		"ApplyPageRotation":        func(d *Document) { d.ApplyPageRotation = !d.ApplyPageRotation },
+		"ApplyExifOrientation":     func(d *Document) { d.ApplyExifOrientation = !d.ApplyExifOrientation },
+		"CompositeImages":          func(d *Document) { d.CompositeImages = !d.CompositeImages },
+		"ConcatenateImageStrips":   func(d *Document) { d.ConcatenateImageStrips = !d.ConcatenateImageStrips },
+		"RenderFallback":           func(d *Document) { d.RenderFallback = !d.RenderFallback },
+		"RenderDPI":                func(d *Document) { d.RenderDPI++ },
+		"MaxDecodedPixels":         func(d *Document) { d.MaxDecodedPixels++ },
+		"DownscaleOversizedImages": func(d *Document) { d.DownscaleOversizedImages = !d.DownscaleOversizedImages },
+	}
+	base := newEmptyDocument().cacheKey(0)
+	for name, change := range changes {
+		d := newEmptyDocument()
+		change(d)
+		if d.cacheKey(0) == base {
+			t.Errorf("changing %v doesn't change the cache key", name)
+		}
+	}
+
+	d := newEmptyDocument()
+	d.OutputQuality = 50
+	if d.cacheKey(0) != base {
+		t.Errorf("changing OutputQuality, which doesn't affect the decoded image, changes the cache key")
+	}
+	if d.cacheKey(1) == base {
+		t.Errorf("pages 0 and 1 have the same cache key")
+	}
+}
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := imageCache{}
+	d := newEmptyDocument()
+	img := cimg.NewImage(1, 1, cimg.PixelFormatGRAY)
+	c.put(d.cacheKey(0), []byte{0}, img, 2)
+	c.put(d.cacheKey(1), []byte{1}, img, 2)
+	// Touch page 0, so that page 1 is the least recently used
+	if raw, _, ok := c.get(d.cacheKey(0)); !ok || raw[0] != 0 {
+		t.Fatalf("page 0 is not cached")
+	}
+	c.put(d.cacheKey(2), []byte{2}, img, 2)
+	if _, _, ok := c.get(d.cacheKey(1)); ok {
+		t.Errorf("page 1 was not evicted")
+	}
+	for _, page := range []int{0, 2} {
+		if _, _, ok := c.get(d.cacheKey(page)); !ok {
+			t.Errorf("page %v was evicted", page)
+		}
+	}
+	c.clear()
+	if _, _, ok := c.get(d.cacheKey(0)); ok {
+		t.Errorf("page 0 is still cached after clear")
+	}
+}
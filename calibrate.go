@@ -0,0 +1,38 @@
+package pdfstraighten
+
+import (
+	"context"
+)
+
+// Search range of RawPageAngles, in degrees either side of zero. Beyond 45 degrees, a skew is indistinguishable
+// from a quarter turn in the other direction.
+const rawAngleRange = 45
+
+// Returns the skew of every page as measured by docangle.GetAngleWhiteLines between -45 and +45 degrees,
+// with none of the policy of PageAngles: no quarter turns, no AutoWiden, no SmoothAngles, no AngleOverrides,
+// and no custom AngleDetector. Only the preparation of the image (DetectMaxDimension and DetectBinarize) applies.
+// This is intended for calibration, such as measuring the mechanical skew of a scanner over many pages.
+// Searching the whole range is far slower than PageAngles with a small maxAngle.
+func (d *Document) RawPageAngles() ([]float64, error) {
+	detector := d.whiteLinesDetector()
+	angles := make([]float64, d.NumPages)
+	err := d.forEachPage(context.Background(), d.allPages(), func(i, page int) error {
+		angle, err := withPageTimeout(d, page, func() (float64, error) {
+			_, img, err := d.getCachedImageOnPage(page)
+			if err != nil {
+				return 0, err
+			}
+			angle, _ := detector.DetectAngle(img, -rawAngleRange, rawAngleRange, false)
+			return angle, nil
+		})
+		if err != nil {
+			return err
+		}
+		angles[i] = angle
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return angles, nil
+}
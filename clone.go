@@ -0,0 +1,16 @@
+package pdfstraighten
+
+import (
+	"maps"
+)
+
+// Returns a Document with the Settings and AngleOverrides of d, but no content, to serve as a template for
+// other documents. Copy its settings to a new document with doc.Settings = template.Settings.
+// Reference settings, such as OCR, AngleDetector, and the callbacks, are shared with d, as are the targets
+// of pointer settings, such as Metadata and PDFConfiguration (see Settings).
+func (d *Document) Clone() *Document {
+	clone := newEmptyDocument()
+	clone.Settings = d.Settings
+	clone.AngleOverrides = maps.Clone(d.AngleOverrides)
+	return clone
+}
@@ -0,0 +1,24 @@
+package pdfstraighten
+
+import "testing"
+
+func TestClone(t *testing.T) {
+	d := newEmptyDocument()
+	d.NumPages = 3
+	d.OutputQuality = 60
+	d.AngleOverrides = map[int]float64{1: 2.5}
+	clone := d.Clone()
+	if clone.OutputQuality != 60 || clone.AngleOverrides[1] != 2.5 {
+		t.Errorf("settings were not copied: OutputQuality %v, AngleOverrides %v", clone.OutputQuality, clone.AngleOverrides)
+	}
+	if clone.NumPages != 0 || clone.documentSource == d.documentSource {
+		t.Errorf("the clone has the content of the original")
+	}
+	clone.AngleOverrides[1] = 0
+	if d.AngleOverrides[1] != 2.5 {
+		t.Errorf("AngleOverrides is shared with the clone")
+	}
+	if q := DefaultSettings().OutputQuality; q != 95 {
+		t.Errorf("default OutputQuality is %v, expected 95", q)
+	}
+}
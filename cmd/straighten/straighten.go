@@ -42,16 +42,12 @@ func main() {
 	angles, err := doc.PageAngles(maxAngle, true)
 	check(err)
 	nRotated := 0
-	for i, a := range angles {
+	for _, a := range angles {
 		if a != 0 {
 			nRotated++
-			if !allow90Degrees && (a > 80 && a < 100) {
-				// Instead of rotating 90 degrees, and thereby requiring landscape pages,
-				// just rotate to straighten the page.
-				angles[i] = a - 90
-			}
 		}
 	}
+	angles = pdfstraighten.NormalizeAngles(angles, allow90Degrees)
 	if nRotated == 0 {
 		fmt.Printf("Document is already 100%% straight\n")
 		return
@@ -61,7 +57,7 @@ func main() {
 		// PDF
 		straight, err := doc.Straighten(orient, angles)
 		check(err)
-		os.WriteFile("straightened.pdf", straight, 0644)
+		check(os.WriteFile("straightened.pdf", straight, 0644))
 	} else {
 		// Images
 		images, err := doc.StraightenedImages(orient, angles)
@@ -0,0 +1,25 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+
+	"github.com/bmharper/cimg/v2"
+)
+
+// Returns true if raw is a JPEG with four components, which is CMYK (or YCCK, its YCbCr-compressed form)
+func isCMYKJPEG(raw []byte) bool {
+	components, ok := jpegFrameComponents(raw)
+	return ok && len(components) == 4*3
+}
+
+// Decode a CMYK JPEG to RGB. turbojpeg can't convert CMYK to RGB, but the Go decoder can, and it
+// also understands the inverted CMYK that Adobe software writes.
+func decodeCMYKJPEG(raw []byte) (*cimg.Image, error) {
+	decoded, err := jpeg.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode CMYK JPEG: %w", err)
+	}
+	return fromGoImage(decoded)
+}
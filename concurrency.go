@@ -0,0 +1,131 @@
+package pdfstraighten
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Run fn on each of the given pages, using up to d.Concurrency goroutines.
+// fn receives the position i within pages, and the page index.
+// fn must store its results by i, because pages may complete in any order.
+// ctx is checked between pages, and the first error returned by fn aborts the remaining pages.
+// d.ProgressFunc is invoked before each page, and d.OnPageError decides whether an error is fatal.
+// When a page error is forgiven, fn's result for that page is left as it was.
+func (d *Document) forEachPage(ctx context.Context, pages []int, fn func(i, page int) error) error {
+	fn = d.withProgress(d.withPageErrors(fn), len(pages))
+	if d.Concurrency < 2 {
+		for i, page := range pages {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(i, page); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+
+	work := make(chan int)
+	for range d.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := fn(i, pages[i]); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range pages {
+		select {
+		case work <- i:
+		case <-workCtx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (d *Document) withProgress(fn func(i, page int) error, total int) func(i, page int) error {
+	if d.ProgressFunc == nil {
+		return fn
+	}
+	return func(i, page int) error {
+		d.ProgressFunc(i, total)
+		return fn(i, page)
+	}
+}
+
+func (d *Document) withPageErrors(fn func(i, page int) error) func(i, page int) error {
+	if d.OnPageError == nil {
+		return fn
+	}
+	return func(i, page int) error {
+		if err := fn(i, page); err != nil {
+			return d.OnPageError(page, err)
+		}
+		return nil
+	}
+}
+
+// Returns the indices of every page in the document
+func (d *Document) allPages() []int {
+	pages := make([]int, d.NumPages)
+	for i := range pages {
+		pages[i] = i
+	}
+	return pages
+}
+
+// Run work, and return its result, unless it takes longer than PerPageTimeout, in which case return ErrPageTimeout.
+// A timed out goroutine can't be stopped (docangle and cimg run in C), so it is abandoned, and keeps running until
+// it finishes by itself, and Close waits for it. For that reason, work must not store its result anywhere.
+// Callers store what this returns.
+func withPageTimeout[T any](d *Document, page int, work func() (T, error)) (T, error) {
+	if d.PerPageTimeout <= 0 {
+		return work()
+	}
+	type outcome struct {
+		result T
+		err    error
+	}
+	// Buffered, so that an abandoned goroutine doesn't block forever on sending its outcome
+	done := make(chan outcome, 1)
+	d.abandoned.Add(1)
+	go func() {
+		defer d.abandoned.Done()
+		result, err := work()
+		done <- outcome{result, err}
+	}()
+	timer := time.NewTimer(d.PerPageTimeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-timer.C:
+		var zero T
+		d.verbose("page %v: abandoned after %v\n", page+1, d.PerPageTimeout)
+		return zero, newPageError(page, fmt.Errorf("%w (%v)", ErrPageTimeout, d.PerPageTimeout))
+	}
+}
@@ -0,0 +1,71 @@
+package pdfstraighten
+
+import (
+	"math"
+
+	"github.com/bmharper/cimg/v2"
+	"github.com/bmharper/docangle"
+)
+
+// Stages of the pipeline at which DebugSink is called
+const (
+	DebugStageDetect  = "detect"  // The grayscale (and possibly binarized) image that the angle is measured on
+	DebugStageAngle   = "angle"   // The detection image, with lines drawn along the detected angle
+	DebugStageRotated = "rotated" // The page after it was rotated by the detected angle, before it was turned upright
+	DebugStageUpright = "upright" // The final page image, after MakeUpright, Check180 and AutoCrop
+)
+
+// Number of lines drawn across the DebugStageAngle image
+const debugAngleLines = 12
+
+// Pass img to DebugSink, if it is set
+func (d *Document) debugImage(page int, stage string, img *cimg.Image) {
+	if d.DebugSink != nil {
+		d.DebugSink(page, stage, img)
+	}
+}
+
+// Report the detection image of img, and the angle that was detected on it, to DebugSink
+func (d *Document) debugDetection(page int, detector AngleDetector, img *cimg.Image, angle float64) {
+	if d.DebugSink == nil {
+		return
+	}
+	// A custom detector doesn't expose its working image, so show what the default detector would see
+	var docImg *docangle.Image
+	if wl, ok := detector.(*WhiteLinesDetector); ok {
+		docImg = wl.detectionImage(img)
+	} else {
+		docImg = makeDocAngleImage(img, d.DetectMaxDimension)
+	}
+	gray := cimg.WrapImage(docImg.Width, docImg.Height, cimg.PixelFormatGRAY, docImg.Pixels)
+	d.DebugSink(page, DebugStageDetect, gray)
+	overlay := gray.ToRGB()
+	drawAngleLines(overlay, angle, debugAngleLines, []byte{255, 0, 0})
+	d.DebugSink(page, DebugStageAngle, overlay)
+}
+
+// Draw n evenly spaced lines across img at angle degrees, which is how docangle measures the direction
+// of a line of text (a positive angle slopes downwards to the right, in image coordinates).
+// fill is the bytes of a single pixel in the format of img.
+func drawAngleLines(img *cimg.Image, angle float64, n int, fill []byte) {
+	dx := math.Cos(angle * math.Pi / 180)
+	dy := math.Sin(angle * math.Pi / 180)
+	cx := float64(img.Width) / 2
+	cy := float64(img.Height) / 2
+	diagonal := math.Hypot(float64(img.Width), float64(img.Height))
+	nchan := img.NChan()
+	for i := range n {
+		// Offset of the line from the center, along the normal of its direction
+		offset := diagonal*(float64(i)+0.5)/float64(n) - diagonal/2
+		ox := cx - dy*offset
+		oy := cy + dx*offset
+		for t := -diagonal / 2; t <= diagonal/2; t++ {
+			x := int(ox + dx*t)
+			y := int(oy + dy*t)
+			if x < 0 || y < 0 || x >= img.Width || y >= img.Height {
+				continue
+			}
+			copy(img.Pixels[y*img.Stride+x*nchan:], fill)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package pdfstraighten
+
+import (
+	"github.com/bmharper/cimg/v2"
+	"github.com/bmharper/docangle"
+)
+
+// AngleDetector measures the skew of a page image, in degrees, searching between minAngle and maxAngle.
+// If include90Degrees is true, the search also covers the same range around 90 degrees.
+// confidence is zero if no angle could be trusted, and is otherwise specific to the detector.
+type AngleDetector interface {
+	DetectAngle(img *cimg.Image, minAngle, maxAngle float64, include90Degrees bool) (angle, confidence float64)
+}
+
+// WhiteLinesDetector is the default AngleDetector, which uses docangle.GetAngleWhiteLines.
+// It works best on pages of text, because it looks for the white gaps between lines.
+type WhiteLinesDetector struct {
+	// The image is scaled down so that neither side exceeds this many pixels before measuring its angle.
+	// Zero disables scaling.
+	MaxDimension int
+
+	// If true, the scaled down image is binarized with an adaptive threshold before measuring its angle.
+	// This strengthens the text of faint scans, such as receipts and carbon copies, whose white gaps between
+	// lines are otherwise barely distinguishable from the lines themselves.
+	Binarize bool
+
+	// Side of the neighbourhood of each pixel that the threshold adapts to, in pixels of the scaled down image.
+	// It should be a few times the height of the text. Zero means 25.
+	BinarizeWindow int
+}
+
+const defaultBinarizeWindow = 25
+
+func (w *WhiteLinesDetector) DetectAngle(img *cimg.Image, minAngle, maxAngle float64, include90Degrees bool) (float64, float64) {
+	docImg := w.detectionImage(img)
+	params := docangle.NewWhiteLinesParams()
+	// We've already downscaled the image
+	params.MaxResolution = 0
+	params.Include90Degrees = include90Degrees
+	params.MinDeltaDegrees = minAngle
+	params.MaxDeltaDegrees = maxAngle
+	score, angle := docangle.GetAngleWhiteLines(docImg, params)
+	return angle, score
+}
+
+// Returns the scaled down, and possibly binarized, grayscale image that the angle of img is measured on
+func (w *WhiteLinesDetector) detectionImage(img *cimg.Image) *docangle.Image {
+	docImg := makeDocAngleImage(img, w.MaxDimension)
+	if w.Binarize {
+		window := w.BinarizeWindow
+		if window == 0 {
+			window = defaultBinarizeWindow
+		}
+		binarizeSauvola(docImg, window)
+	}
+	return docImg
+}
@@ -0,0 +1,85 @@
+package pdfstraighten
+
+import (
+	"math"
+
+	"github.com/bmharper/cimg/v2"
+)
+
+// Resolution of the edge direction histogram of EdgeDetector, in bins per degree
+const edgeBinsPerDegree = 10
+
+// Sobel gradient magnitude below which a pixel is not considered to be on an edge
+const edgeMinMagnitude = 64
+
+// EdgeDetector is an AngleDetector for pages that aren't made of lines of text, such as tables, forms,
+// photos, and handwriting, on which WhiteLinesDetector finds no white gaps to measure. It builds a histogram
+// of the direction of every edge on the page (from the Sobel gradient), and returns the dominant direction.
+// Horizontal and vertical edges count equally, so it can't tell a quarter turn apart from no turn, and ignores
+// include90Degrees: the angle is always the skew within [-45, 45), and the orientation is left to MakeUpright.
+// confidence is the fraction (0..1) of edge strength within half a degree of the dominant direction.
+// See Document.FallbackDetector.
+type EdgeDetector struct {
+	// The image is scaled down so that neither side exceeds this many pixels before measuring its angle.
+	// Zero disables scaling.
+	MaxDimension int
+}
+
+func (e *EdgeDetector) DetectAngle(img *cimg.Image, minAngle, maxAngle float64, include90Degrees bool) (float64, float64) {
+	docImg := makeDocAngleImage(img, e.MaxDimension)
+	width, height, pix := docImg.Width, docImg.Height, docImg.Pixels
+
+	// Histogram of edge strength over skew angles in [-45, 45)
+	nBins := 90 * edgeBinsPerDegree
+	hist := make([]float64, nBins)
+	total := 0.0
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			at := func(dx, dy int) float64 {
+				return float64(pix[(y+dy)*width+x+dx])
+			}
+			gx := at(1, -1) + 2*at(1, 0) + at(1, 1) - at(-1, -1) - 2*at(-1, 0) - at(-1, 1)
+			gy := at(-1, 1) + 2*at(0, 1) + at(1, 1) - at(-1, -1) - 2*at(0, -1) - at(1, -1)
+			magnitude := math.Hypot(gx, gy)
+			if magnitude < edgeMinMagnitude {
+				continue
+			}
+			// The gradient is perpendicular to the edge, but after folding into a quarter turn, that doesn't matter
+			skew := skewOnly(math.Atan2(gy, gx) * 180 / math.Pi)
+			bin := min(nBins-1, int((skew+45)*edgeBinsPerDegree))
+			hist[bin] += magnitude
+			total += magnitude
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+
+	// Find the strongest direction within the search range, measuring the strength of each bin together with its
+	// neighbours within half a degree, because the direction of each individual edge is only roughly known.
+	if maxAngle-minAngle >= 90 {
+		minAngle, maxAngle = -45, 45
+	}
+	half := edgeBinsPerDegree / 2
+	bestStrength := -1.0
+	bestBin := 0
+	for bin := range nBins {
+		skew := float64(bin)/edgeBinsPerDegree - 45
+		if skew < minAngle || skew > maxAngle {
+			continue
+		}
+		strength := 0.0
+		for i := bin - half; i <= bin+half; i++ {
+			// The histogram wraps around, because -45 and 45 degrees are the same direction
+			strength += hist[(i+nBins)%nBins]
+		}
+		if strength > bestStrength {
+			bestStrength = strength
+			bestBin = bin
+		}
+	}
+	if bestStrength < 0 {
+		return 0, 0
+	}
+	return float64(bestBin)/edgeBinsPerDegree - 45, bestStrength / total
+}
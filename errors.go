@@ -0,0 +1,42 @@
+package pdfstraighten
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors that are returned by Document, usually wrapped in a *PageError, so that they can be tested with errors.Is
+var (
+	ErrNoImageOnPage        = errors.New("No image found")                                            // The page has no image that we can extract
+	ErrUnexpectedImageCount = errors.New("ExtractImagesRaw returned an unexpected number of results") // pdfcpu misbehaved
+	ErrNotPDF               = errors.New("Page can't be rendered, because the document is not a PDF")
+	ErrPageOutOfRange       = errors.New("Page index is out of range")
+	ErrEveryPageBlank       = errors.New("Every page is blank")              // RemoveBlankPages would produce an empty PDF
+	ErrNotScanned           = errors.New("Document is not scanned")          // Returned by StraightenFile
+	ErrImageTooLarge        = errors.New("Page image is too large")          // Exceeds MaxDecodedPixels
+	ErrRenderTooLarge       = errors.New("Rendered page would be too large") // Lower RenderDPI
+	ErrPageTimeout          = errors.New("Page took longer than PerPageTimeout")
+	ErrUnsupportedCodec     = errors.New("Page image is in a format that we can't decode")
+	ErrAngleCountMismatch   = errors.New("Number of angles does not match number of pages")
+	ErrSizeBudgetExceeded   = errors.New("PDF is larger than the size budget") // Returned by StraightenWithSizeBudget
+	ErrInvalidPageOrder     = errors.New("Page order must contain every page exactly once")
+)
+
+// PageError is an error that happened while processing a particular page.
+// Use errors.As to retrieve the page, and errors.Is to test the cause.
+type PageError struct {
+	Page int // Zero-based page index
+	Err  error
+}
+
+func (e *PageError) Error() string {
+	return fmt.Sprintf("%v on page %v", e.Err, e.Page+1)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+func newPageError(page int, err error) error {
+	return &PageError{Page: page, Err: err}
+}
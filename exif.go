@@ -0,0 +1,24 @@
+package pdfstraighten
+
+import (
+	"github.com/bmharper/cimg/v2"
+)
+
+// If raw is a JPEG with an EXIF orientation tag, return img (the decoding of raw) turned to the orientation
+// in which the image is meant to be displayed. Otherwise, return img itself.
+// Mirrored orientations are rare, and cimg can't undo them, so they are ignored.
+func applyExifOrientation(raw []byte, img *cimg.Image) (*cimg.Image, error) {
+	if sniffFormat(raw) != imageJPEG {
+		return img, nil
+	}
+	exif, err := cimg.LoadExif(raw)
+	if err != nil {
+		// A damaged EXIF block is no reason to fail, since the pixels decoded fine
+		return img, nil
+	}
+	switch orientation := exif.GetOrientation(); orientation {
+	case 3, 6, 8:
+		return cimg.UnrotateExif(orientation, img)
+	}
+	return img, nil
+}
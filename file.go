@@ -0,0 +1,32 @@
+package pdfstraighten
+
+import (
+	"fmt"
+	"os"
+)
+
+// Straighten the scanned PDF inPath, and write the result to outPath.
+// If inPath is not a scanned document, nothing is written, and the error satisfies errors.Is(err, ErrNotScanned),
+// so that the caller can decide to copy the file through unchanged.
+func StraightenFile(inPath, outPath string, opts Options) error {
+	doc, err := NewDocumentFromFile(inPath)
+	if err != nil {
+		return err
+	}
+	defer doc.Close()
+	opts.configure(doc)
+
+	scan, err := doc.IsScannedDetailed()
+	if err != nil {
+		return err
+	}
+	if !scan.Scanned {
+		return newPageError(scan.Page, fmt.Errorf("%w (%v)", ErrNotScanned, scan.Reason))
+	}
+
+	straight, err := doc.straightenWithOptions(&opts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outPath, straight, 0644)
+}
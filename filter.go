@@ -0,0 +1,74 @@
+package pdfstraighten
+
+import (
+	"github.com/bmharper/cimg/v2"
+)
+
+// Apply Denoise and then PostSharpen to img. Returns img itself if neither is set, and otherwise a new image,
+// because img may be shared with the image cache.
+func (d *Document) postFilter(img *cimg.Image) *cimg.Image {
+	if d.Denoise {
+		img = medianFilter3x3(img)
+	}
+	if d.PostSharpen > 0 {
+		img = unsharpMask(img, d.PostSharpen)
+	}
+	return img
+}
+
+// Returns img with every channel of every pixel replaced by the median of its 3x3 neighbourhood, which removes
+// isolated specks without blurring edges. The outermost pixels are copied unchanged.
+func medianFilter3x3(img *cimg.Image) *cimg.Image {
+	out := img.Clone()
+	nchan := img.NChan()
+	var window [9]byte
+	for y := 1; y < img.Height-1; y++ {
+		for x := 1; x < img.Width-1; x++ {
+			for c := range nchan {
+				n := 0
+				for dy := -1; dy <= 1; dy++ {
+					row := (y + dy) * img.Stride
+					for dx := -1; dx <= 1; dx++ {
+						window[n] = img.Pixels[row+(x+dx)*nchan+c]
+						n++
+					}
+				}
+				// Insertion sort is the fastest way to sort 9 values
+				for i := 1; i < len(window); i++ {
+					for j := i; j > 0 && window[j] < window[j-1]; j-- {
+						window[j], window[j-1] = window[j-1], window[j]
+					}
+				}
+				out.Pixels[y*out.Stride+x*nchan+c] = window[4]
+			}
+		}
+	}
+	return out
+}
+
+// Returns img sharpened by an unsharp mask: each pixel moves away from the 3x3 Gaussian blur of its
+// neighbourhood by amount times the difference. The outermost pixels are copied unchanged.
+func unsharpMask(img *cimg.Image, amount float64) *cimg.Image {
+	out := img.Clone()
+	nchan := img.NChan()
+	// 1 2 1 / 2 4 2 / 1 2 1
+	weights := [3][3]float64{{1, 2, 1}, {2, 4, 2}, {1, 2, 1}}
+	for y := 1; y < img.Height-1; y++ {
+		for x := 1; x < img.Width-1; x++ {
+			for c := range nchan {
+				blur := 0.0
+				for dy := -1; dy <= 1; dy++ {
+					row := (y + dy) * img.Stride
+					for dx := -1; dx <= 1; dx++ {
+						blur += weights[dy+1][dx+1] * float64(img.Pixels[row+(x+dx)*nchan+c])
+					}
+				}
+				blur /= 16
+				v := float64(img.Pixels[y*img.Stride+x*nchan+c])
+				sharp := v + amount*(v-blur)
+				out.Pixels[y*out.Stride+x*nchan+c] = byte(min(255, max(0, sharp+0.5)))
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,279 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	"github.com/bmharper/cimg/v2"
+	"golang.org/x/image/tiff"
+)
+
+// OutputFormat is the image encoding used for straightened pages
+type OutputFormat int
+
+const (
+	FormatJPEG    OutputFormat = iota // Lossy, small. Controlled by OutputQuality and OutputSampling.
+	FormatPNG                         // Lossless. Best for line drawings and text, where JPEG ringing hurts OCR.
+	FormatBilevel                     // Black and white, 1 bit per pixel. Tiny, and ideal for fax-quality scans.
+
+	// Smaller than JPEG at the same quality, and controlled by OutputQuality. Requires Document.WebPEncoder.
+	// PDF has no WebP image filter, so this is only available from StraightenedImages and StraightenImageBytes.
+	FormatWebP
+)
+
+// imageFormat is the encoding of an image blob, as judged by its magic number
+type imageFormat int
+
+const (
+	imageUnknown imageFormat = iota
+	imageJPEG
+	imagePNG
+	imageTIFF // Either byte order
+	imageJP2  // JPEG 2000, in its JP2 container
+	imageJ2K  // A bare JPEG 2000 codestream
+	imageWebP
+)
+
+// Returns the format of an encoded image, judging by its magic number
+func sniffFormat(raw []byte) imageFormat {
+	switch {
+	case bytes.HasPrefix(raw, []byte("\xff\xd8\xff")):
+		return imageJPEG
+	case bytes.HasPrefix(raw, []byte("\x89PNG\r\n\x1a\n")):
+		return imagePNG
+	case bytes.HasPrefix(raw, []byte("II*\x00")), bytes.HasPrefix(raw, []byte("MM\x00*")):
+		return imageTIFF
+	case bytes.HasPrefix(raw, []byte("\x00\x00\x00\x0cjP  \r\n\x87\n")):
+		return imageJP2
+	case bytes.HasPrefix(raw, []byte("\xff\x4f\xff\x51")):
+		return imageJ2K
+	case len(raw) > 12 && bytes.HasPrefix(raw, []byte("RIFF")) && bytes.Equal(raw[8:12], []byte("WEBP")):
+		return imageWebP
+	}
+	return imageUnknown
+}
+
+// The MIME type and file extension (including the dot) of each imageFormat.
+// A bare JPEG 2000 codestream has no registered MIME type.
+var imageFormatTypes = [...]struct{ mimeType, extension string }{
+	imageUnknown: {"application/octet-stream", ".bin"},
+	imageJPEG:    {"image/jpeg", ".jpg"},
+	imagePNG:     {"image/png", ".png"},
+	imageTIFF:    {"image/tiff", ".tif"},
+	imageJP2:     {"image/jp2", ".jp2"},
+	imageJ2K:     {"application/octet-stream", ".j2k"},
+	imageWebP:    {"image/webp", ".webp"},
+}
+
+// Decode an image that was extracted from a page, choosing the decoder by the magic number of raw.
+// turbojpeg would otherwise be handed anything that isn't a little endian TIFF or a PNG, and fail with an
+// obscure error. Formats that we can't decode, such as JPEG 2000, fail with ErrUnsupportedCodec.
+func decodeImage(raw []byte) (*cimg.Image, error) {
+	switch sniffFormat(raw) {
+	case imageJPEG:
+		if isCMYKJPEG(raw) {
+			return decodeCMYKJPEG(raw)
+		}
+		return cimg.Decompress(raw)
+	case imagePNG:
+		return cimg.Decompress(raw)
+	case imageTIFF:
+		if bytes.HasPrefix(raw, []byte("II")) {
+			return cimg.Decompress(raw)
+		}
+		// cimg only recognizes little endian TIFF
+		decoded, err := tiff.Decode(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		return fromGoImage(decoded)
+	case imageJP2, imageJ2K:
+		return nil, fmt.Errorf("%w (JPEG 2000)", ErrUnsupportedCodec)
+	}
+	return nil, fmt.Errorf("%w (magic number % x)", ErrUnsupportedCodec, raw[:min(len(raw), 4)])
+}
+
+// Returns true if the original image blob of a page can be emitted verbatim in the document's output format
+func (d *Document) acceptsRawImage(raw []byte) bool {
+	if isCMYKJPEG(raw) {
+		// The page was decoded to RGB, so re-encode it as RGB rather than emitting CMYK
+		return false
+	}
+	if d.OutputGrayscale && d.OutputFormat != FormatBilevel && !isGrayImage(raw) {
+		return false
+	}
+	switch d.OutputFormat {
+	case FormatPNG:
+		return sniffFormat(raw) == imagePNG
+	case FormatBilevel:
+		return isBilevelPNG(raw)
+	default:
+		return true
+	}
+}
+
+// Returns true if the encoded image has a single channel
+func isGrayImage(raw []byte) bool {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	return err == nil && (cfg.ColorModel == color.GrayModel || cfg.ColorModel == color.Gray16Model)
+}
+
+// Largest fraction of pixels that may have a chroma above AutoGrayscaleChroma, in an image that is effectively gray
+const autoGrayscaleColorFraction = 0.001
+
+// Number of pixels that isAutoGrayscale samples, at most
+const autoGrayscaleSamples = 250_000
+
+// Returns true if AutoGrayscale is set, and img is a color image whose pixels are effectively gray
+func (d *Document) isAutoGrayscale(img *cimg.Image) bool {
+	nchan := img.NChan()
+	if !d.AutoGrayscale || nchan < 3 || img.Format == cimg.PixelFormatCMYK || img.Width == 0 || img.Height == 0 {
+		return false
+	}
+	// The order of the color channels doesn't matter, but they must not include alpha
+	first := 0
+	switch img.Format {
+	case cimg.PixelFormatXBGR, cimg.PixelFormatXRGB, cimg.PixelFormatABGR, cimg.PixelFormatARGB:
+		first = 1
+	}
+	// Sample a grid of pixels, because a page is far larger than it needs to be to judge its color
+	step := max(1, int(math.Sqrt(float64(img.Width*img.Height)/autoGrayscaleSamples)))
+	samples := 0
+	colored := 0
+	for y := 0; y < img.Height; y += step {
+		row := img.Pixels[y*img.Stride:]
+		for x := 0; x < img.Width; x += step {
+			p := row[x*nchan+first : x*nchan+first+3]
+			chroma := int(max(p[0], p[1], p[2])) - int(min(p[0], p[1], p[2]))
+			if chroma > d.AutoGrayscaleChroma {
+				colored++
+			}
+			samples++
+		}
+	}
+	return float64(colored) <= autoGrayscaleColorFraction*float64(samples)
+}
+
+// Returns the component specifications of the frame header of a JPEG blob, which are 3 bytes per component:
+// identifier, sampling factors, and quantization table. The second return value is false if raw is not a JPEG.
+func jpegFrameComponents(raw []byte) ([]byte, bool) {
+	if len(raw) < 4 || raw[0] != 0xff || raw[1] != 0xd8 {
+		return nil, false
+	}
+	for i := 2; i+4 <= len(raw); {
+		if raw[i] != 0xff {
+			return nil, false
+		}
+		marker := raw[i+1]
+		if marker == 0xff {
+			// Fill byte
+			i++
+			continue
+		}
+		if marker == 0xd8 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			// Markers without a length
+			i += 2
+			continue
+		}
+		length := int(raw[i+2])<<8 | int(raw[i+3])
+		// SOF0..SOF15, excluding DHT (c4), JPG (c8) and DAC (cc)
+		isSOF := marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc
+		if !isSOF {
+			if marker == 0xda || marker == 0xd9 {
+				// Start of scan, or end of image, before any frame header
+				return nil, false
+			}
+			i += 2 + length
+			continue
+		}
+		// Frame header: length(2) precision(1) height(2) width(2) components(1), then the components
+		header := raw[i+4:]
+		if len(header) < 6 {
+			return nil, false
+		}
+		nComponents := int(header[5])
+		if len(header) < 6+3*nComponents {
+			return nil, false
+		}
+		return header[6 : 6+3*nComponents], true
+	}
+	return nil, false
+}
+
+// Returns the chroma sampling of a JPEG blob.
+// The second return value is false if raw is not a JPEG, or its sampling is not one that cimg can produce.
+func jpegSampling(raw []byte) (cimg.Sampling, bool) {
+	components, ok := jpegFrameComponents(raw)
+	if !ok {
+		return 0, false
+	}
+	if len(components) == 3 {
+		return cimg.SamplingGray, true
+	}
+	if len(components) != 3*3 {
+		return 0, false
+	}
+	// The sampling factors of each component are packed into a byte as horizontal<<4 | vertical
+	for c := 1; c < 3; c++ {
+		if components[3*c+1] != 0x11 {
+			return 0, false
+		}
+	}
+	switch components[1] {
+	case 0x11:
+		return cimg.Sampling444, true
+	case 0x21:
+		return cimg.Sampling422, true
+	case 0x22:
+		return cimg.Sampling420, true
+	}
+	return 0, false
+}
+
+// Encode img using the document's output format.
+// source is the original blob that img was decoded from, or nil if there is none.
+func (d *Document) encodeImage(img *cimg.Image, source []byte) ([]byte, error) {
+	if (d.OutputGrayscale || d.isAutoGrayscale(img)) && img.NChan() != 1 {
+		img = img.ToGray()
+	}
+	switch d.OutputFormat {
+	case FormatBilevel:
+		return encodeBilevelPNG(img)
+	case FormatWebP:
+		if d.WebPEncoder == nil {
+			return nil, fmt.Errorf("FormatWebP requires Document.WebPEncoder, because cimg has no WebP encoder")
+		}
+		goImg, err := img.ToImage()
+		if err != nil {
+			return nil, err
+		}
+		return d.WebPEncoder(goImg, d.OutputQuality)
+	case FormatPNG:
+		goImg, err := img.ToImage()
+		if err != nil {
+			return nil, err
+		}
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, goImg); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		params := d.compressParams()
+		if d.MatchSourceSampling {
+			// cimg decodes every JPEG to RGB, so a gray source must be made gray again, and cimg
+			// chooses gray sampling by itself for a gray image.
+			if sampling, ok := jpegSampling(source); ok {
+				if sampling == cimg.SamplingGray && img.NChan() != 1 {
+					img = img.ToGray()
+				}
+				params.Sampling = sampling
+			}
+		}
+		return cimg.Compress(img, params)
+	}
+}
@@ -0,0 +1,112 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/bmharper/cimg/v2"
+)
+
+// Returns a JPEG of a gray gradient
+func grayJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x * 4)})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeImageMatchesGraySource(t *testing.T) {
+	source := grayJPEG(t)
+	if sampling, ok := jpegSampling(source); !ok || sampling != cimg.SamplingGray {
+		t.Fatalf("source sampling is %v (ok %v), expected gray", sampling, ok)
+	}
+	img, err := cimg.Decompress(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := newEmptyDocument()
+	doc.MatchSourceSampling = true
+	encoded, err := doc.encodeImage(img, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sampling, ok := jpegSampling(encoded); !ok || sampling != cimg.SamplingGray {
+		t.Errorf("encoded sampling is %v (ok %v), expected gray", sampling, ok)
+	}
+}
+
+// Returns the start of a JPEG whose frame has a component with each of the given sampling factors
+func jpegHeader(samplings ...byte) []byte {
+	// SOI, then an APP0 segment, which must be skipped, then the frame header
+	raw := []byte{0xff, 0xd8, 0xff, 0xe0, 0, 4, 'x', 'x'}
+	raw = append(raw, 0xff, 0xc0, 0, byte(8+3*len(samplings)), 8, 0, 16, 0, 16, byte(len(samplings)))
+	for i, s := range samplings {
+		raw = append(raw, byte(i+1), s, 0)
+	}
+	return raw
+}
+
+func TestJPEGSampling(t *testing.T) {
+	cases := []struct {
+		name     string
+		raw      []byte
+		sampling cimg.Sampling
+		ok       bool
+	}{
+		{"gray", jpegHeader(0x11), cimg.SamplingGray, true},
+		{"4:4:4", jpegHeader(0x11, 0x11, 0x11), cimg.Sampling444, true},
+		{"4:2:2", jpegHeader(0x21, 0x11, 0x11), cimg.Sampling422, true},
+		{"4:2:0", jpegHeader(0x22, 0x11, 0x11), cimg.Sampling420, true},
+		{"4:4:0", jpegHeader(0x12, 0x11, 0x11), 0, false},
+		{"subsampled luma", jpegHeader(0x22, 0x22, 0x11), 0, false},
+		{"CMYK", jpegHeader(0x11, 0x11, 0x11, 0x11), 0, false},
+		{"truncated frame", jpegHeader(0x11, 0x11, 0x11)[:20], 0, false},
+		{"scan before frame", []byte{0xff, 0xd8, 0xff, 0xda, 0, 2}, 0, false},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n"), 0, false},
+	}
+	for _, c := range cases {
+		sampling, ok := jpegSampling(c.raw)
+		if ok != c.ok || (ok && sampling != c.sampling) {
+			t.Errorf("%v: got %v (ok %v), expected %v (ok %v)", c.name, sampling, ok, c.sampling, c.ok)
+		}
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	cases := []struct {
+		raw       []byte
+		format    imageFormat
+		mimeType  string
+		extension string
+	}{
+		{[]byte("\xff\xd8\xff\xe0"), imageJPEG, "image/jpeg", ".jpg"},
+		{[]byte("\x89PNG\r\n\x1a\n\x00"), imagePNG, "image/png", ".png"},
+		{[]byte("II*\x00\x08\x00\x00\x00"), imageTIFF, "image/tiff", ".tif"},
+		{[]byte("MM\x00*\x00\x00\x00\x08"), imageTIFF, "image/tiff", ".tif"},
+		{[]byte("\x00\x00\x00\x0cjP  \r\n\x87\n"), imageJP2, "image/jp2", ".jp2"},
+		{[]byte("\xff\x4f\xff\x51\x00"), imageJ2K, "application/octet-stream", ".j2k"},
+		{[]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), imageWebP, "image/webp", ".webp"},
+		{[]byte("GIF89a"), imageUnknown, "application/octet-stream", ".bin"},
+		{nil, imageUnknown, "application/octet-stream", ".bin"},
+	}
+	for _, c := range cases {
+		format := sniffFormat(c.raw)
+		if format != c.format {
+			t.Errorf("% x: got format %v, expected %v", c.raw, format, c.format)
+		}
+		if types := imageFormatTypes[format]; types.mimeType != c.mimeType || types.extension != c.extension {
+			t.Errorf("% x: got %v %v, expected %v %v", c.raw, types.mimeType, types.extension, c.mimeType, c.extension)
+		}
+	}
+}
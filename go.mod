@@ -10,6 +10,8 @@ require (
 	github.com/bmharper/textorient v1.0.5
 	github.com/gen2brain/go-fitz v1.24.14
 	github.com/pdfcpu/pdfcpu v0.9.1
+	golang.org/x/image v0.25.0
+	golang.org/x/text v0.23.0
 )
 
 require (
@@ -29,8 +31,6 @@ require (
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/image v0.25.0 // indirect
 	golang.org/x/net v0.38.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
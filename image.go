@@ -0,0 +1,45 @@
+package pdfstraighten
+
+import (
+	"github.com/bmharper/cimg/v2"
+	"github.com/bmharper/textorient"
+)
+
+// Straighten a standalone JPEG, PNG, or TIFF image, without any PDF round trip.
+// This runs the same pipeline as Straighten (detect angle, rotate, make upright, compress), using default settings.
+// We only scan between -maxAngle and +maxAngle degrees.
+func StraightenImageBytes(orient *textorient.Orient, raw []byte, maxAngle float64, include90Degrees bool) ([]byte, error) {
+	return newEmptyDocument().StraightenImageBytes(orient, raw, maxAngle, include90Degrees)
+}
+
+// Same as the package-level StraightenImageBytes, but using the settings of this document
+func (d *Document) StraightenImageBytes(orient *textorient.Orient, raw []byte, maxAngle float64, include90Degrees bool) ([]byte, error) {
+	img, err := decodeImage(raw)
+	if err != nil {
+		return nil, err
+	}
+	if flat := flattenAlpha(img); flat != img {
+		img, raw = flat, nil
+	}
+	if d.ApplyExifOrientation {
+		oriented, err := applyExifOrientation(raw, img)
+		if err != nil {
+			return nil, err
+		}
+		if oriented != img {
+			// The original image would be displayed in its EXIF orientation, so we can't pass it through
+			img, raw = oriented, nil
+		}
+	}
+	angle, _ := d.getImageAngle(0, img, maxAngle, include90Degrees)
+	fixed, _, err := d.straightenImage(orient, 0, raw, img, angle)
+	return fixed, err
+}
+
+// Straighten an image that has already been decoded, rotating it by angle (as returned by PageAngles),
+// making it upright, and compressing it in the document's output format.
+// Because there is no original blob to fall back on, the image is always re-encoded, even if angle is zero.
+func (d *Document) StraightenImage(orient *textorient.Orient, img *cimg.Image, angle float64) ([]byte, error) {
+	fixed, _, err := d.straightenImage(orient, 0, nil, img, angle)
+	return fixed, err
+}
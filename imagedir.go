@@ -0,0 +1,86 @@
+package pdfstraighten
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bmharper/textorient"
+)
+
+// Name of the manifest that StraightenToImageDir writes alongside the images
+const ImageDirManifest = "manifest.json"
+
+// Filename pattern of StraightenToImageDir, if none is given
+const defaultImageDirPattern = "page-%04d"
+
+// ImageDirEntry describes one page in the manifest of StraightenToImageDir
+type ImageDirEntry struct {
+	Page        int     `json:"page"`            // Zero-based page index
+	File        string  `json:"file,omitempty"`  // Name of the image within the directory. Empty if the page was skipped.
+	Angle       float64 `json:"angle"`           // Skew correction in degrees, as in PageResult
+	Orientation int     `json:"orientation"`     // Clockwise rotation applied by MakeUpright, as in PageResult
+	Flipped180  bool    `json:"flipped180"`      // True if Check180 turned the page upside down
+	Modified    bool    `json:"modified"`        // False if the image is the original image of the page
+	Blank       bool    `json:"blank,omitempty"` // True if RemoveBlankPages judged the page to be blank
+}
+
+// Given the list of page angles obtained by PageAngles(), write each straightened page to an image file in dir,
+// along with a JSON manifest (ImageDirManifest) that describes what was done to each page. dir is created if needed.
+// pattern is a fmt format with one integer verb, which receives the one-based page number (eg "scan-%03d"). If it is
+// empty, "page-%04d" is used. The extension that matches the encoding of the image (eg ".jpg") is appended.
+// Returns the path of each page's image, which is empty for a page that was skipped because of an OnPageError.
+// Blank pages are written too, like StraightenedImages, and are marked as such in the manifest.
+func (d *Document) StraightenToImageDir(orient *textorient.Orient, pageAngles []float64, dir, pattern string) ([]string, error) {
+	return d.StraightenToImageDirContext(context.Background(), orient, pageAngles, dir, pattern)
+}
+
+// StraightenToImageDirContext is StraightenToImageDir, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenToImageDirContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64, dir, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = defaultImageDirPattern
+	}
+	straightPages, err := d.straightenedImages(ctx, orient, d.allPages(), pageAngles)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(straightPages))
+	manifest := make([]ImageDirEntry, len(straightPages))
+	for i, p := range straightPages {
+		manifest[i] = ImageDirEntry{
+			Page:        p.page,
+			Angle:       p.result.Angle,
+			Orientation: p.result.Orientation,
+			Flipped180:  p.result.Flipped180,
+			Modified:    p.result.Modified,
+			Blank:       p.blank,
+		}
+		if p.image == nil {
+			continue
+		}
+		name := fmt.Sprintf(pattern, p.page+1) + imageExtension(p.image)
+		paths[i] = filepath.Join(dir, name)
+		if err := os.WriteFile(paths[i], p.image, 0644); err != nil {
+			return nil, err
+		}
+		manifest[i].File = name
+	}
+	encoded, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ImageDirManifest), encoded, 0644); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Returns the file extension of an encoded image, including the dot
+func imageExtension(raw []byte) string {
+	return imageFormatTypes[sniffFormat(raw)].extension
+}
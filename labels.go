@@ -0,0 +1,232 @@
+package pdfstraighten
+
+import (
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// A range of pages that share a numbering style, from the /PageLabels number tree of a PDF
+type pageLabelRange struct {
+	start  int    // Index of the first page of the range
+	style  string // D (decimal), R or r (roman), A or a (letters), or empty for no number
+	prefix string
+	first  int // Number of the first page of the range
+}
+
+// Returns the label of every page of the document, such as "iv" or "A-3", as a PDF viewer would show it.
+// Returns nil if the document doesn't define page labels, in which case pages are simply numbered from 1.
+func (d *Document) PageLabels() ([]string, error) {
+	ranges, err := d.sourcePageLabels()
+	if err != nil || ranges == nil {
+		return nil, err
+	}
+	labels := make([]string, d.NumPages)
+	for page := range labels {
+		r, number := labelOfPage(ranges, page)
+		labels[page] = r.prefix + formatPageNumber(r.style, number)
+	}
+	return labels, nil
+}
+
+// Returns the page label ranges of the source PDF, ordered by their first page.
+// Returns nil if there are none.
+func (d *Document) sourcePageLabels() ([]pageLabelRange, error) {
+	if d.reader == nil {
+		return nil, nil
+	}
+	var ctx *model.Context
+	err := d.withReader(func(r io.ReadSeeker) (err error) {
+		ctx, err = pdfapi.ReadContext(r, d.pdfcpuConfig())
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := root.Find("PageLabels")
+	if !ok {
+		return nil, nil
+	}
+	ranges := []pageLabelRange{}
+	if err := readPageLabelTree(ctx, obj, &ranges, 0); err != nil {
+		return nil, err
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(ranges, func(a, b pageLabelRange) int { return a.start - b.start })
+	return ranges, nil
+}
+
+// Append the entries of a node of the /PageLabels number tree, and of its descendants, to ranges.
+// Damaged entries are skipped, because labels are cosmetic.
+func readPageLabelTree(ctx *model.Context, obj types.Object, ranges *[]pageLabelRange, depth int) error {
+	// Real number trees are only a few levels deep, but a damaged one could loop forever
+	if depth > 32 {
+		return nil
+	}
+	node, err := ctx.DereferenceDict(obj)
+	if err != nil || node == nil {
+		return err
+	}
+	if kids, err := ctx.DereferenceArray(node["Kids"]); err == nil {
+		for _, kid := range kids {
+			if err := readPageLabelTree(ctx, kid, ranges, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	nums, err := ctx.DereferenceArray(node["Nums"])
+	if err != nil {
+		return nil
+	}
+	for i := 0; i+1 < len(nums); i += 2 {
+		start, err := ctx.DereferenceInteger(nums[i])
+		if err != nil || start == nil {
+			continue
+		}
+		label, err := ctx.DereferenceDict(nums[i+1])
+		if err != nil || label == nil {
+			continue
+		}
+		r := pageLabelRange{start: start.Value(), first: 1}
+		if style := label.NameEntry("S"); style != nil {
+			r.style = *style
+		}
+		if prefix, ok := label.Find("P"); ok {
+			if r.prefix, err = ctx.DereferenceText(prefix); err != nil {
+				r.prefix = ""
+			}
+		}
+		if first := label.IntEntry("St"); first != nil && *first > 0 {
+			r.first = *first
+		}
+		*ranges = append(*ranges, r)
+	}
+	return nil
+}
+
+// Returns the range of a page, and its number within that range
+func labelOfPage(ranges []pageLabelRange, page int) (pageLabelRange, int) {
+	i, found := slices.BinarySearchFunc(ranges, page, func(r pageLabelRange, page int) int { return r.start - page })
+	if !found {
+		i--
+	}
+	if i < 0 {
+		// The first range should start at page 0, but if it doesn't, we number the pages before it from 1
+		return pageLabelRange{style: "D", first: 1}, page + 1
+	}
+	return ranges[i], ranges[i].first + page - ranges[i].start
+}
+
+// Format a page number in the numbering style of a page label
+func formatPageNumber(style string, number int) string {
+	switch style {
+	case "D":
+		return strconv.Itoa(number)
+	case "R":
+		return romanNumeral(number)
+	case "r":
+		return strings.ToLower(romanNumeral(number))
+	case "A":
+		return letterNumber(number)
+	case "a":
+		return strings.ToLower(letterNumber(number))
+	}
+	return ""
+}
+
+func romanNumeral(number int) string {
+	numerals := []struct {
+		value  int
+		symbol string
+	}{
+		{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"}, {100, "C"}, {90, "XC"},
+		{50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+	}
+	s := &strings.Builder{}
+	for _, n := range numerals {
+		for number >= n.value {
+			s.WriteString(n.symbol)
+			number -= n.value
+		}
+	}
+	return s.String()
+}
+
+// A to Z, then AA to ZZ, then AAA to ZZZ, etc, as the PDF spec defines it
+func letterNumber(number int) string {
+	if number < 1 {
+		return ""
+	}
+	letter := string(rune('A' + (number-1)%26))
+	return strings.Repeat(letter, (number-1)/26+1)
+}
+
+// Give the pages of the output PDF ctx, which was made from pages, the labels of the source pages that they came from.
+// If PreservePageLabels is false, the output has no labels.
+func (d *Document) writePageLabels(ctx *model.Context, pages []straightenedPage) error {
+	if !d.PreservePageLabels {
+		root, err := ctx.Catalog()
+		if err != nil {
+			return err
+		}
+		root.Delete("PageLabels")
+		return nil
+	}
+	ranges, err := d.sourcePageLabels()
+	if err != nil {
+		return err
+	}
+	return setPageLabels(ctx, ranges, outputPageMap(pages))
+}
+
+// Set the /PageLabels of ctx, so that each output page keeps the label of the source page that it came from.
+// pageMap holds the source page of each output page.
+func setPageLabels(ctx *model.Context, ranges []pageLabelRange, pageMap []int) error {
+	if len(ranges) == 0 || len(pageMap) == 0 {
+		return nil
+	}
+	nums := types.Array{}
+	var prev pageLabelRange
+	prevNumber := 0
+	for i, page := range pageMap {
+		r, number := labelOfPage(ranges, page)
+		if i > 0 && r.style == prev.style && r.prefix == prev.prefix && number == prevNumber+1 {
+			// The numbering of the previous output page continues
+			prevNumber = number
+			continue
+		}
+		label := types.NewDict()
+		if r.style != "" {
+			label.InsertName("S", r.style)
+		}
+		if r.prefix != "" {
+			encoded, err := types.EscapedUTF16String(r.prefix)
+			if err != nil {
+				return err
+			}
+			label.Insert("P", types.StringLiteral(*encoded))
+		}
+		if number != 1 {
+			label.InsertInt("St", number)
+		}
+		nums = append(nums, types.Integer(i), label)
+		prev, prevNumber = r, number
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	root.Update("PageLabels", types.Dict{"Nums": nums})
+	return nil
+}
@@ -0,0 +1,40 @@
+package pdfstraighten
+
+import "testing"
+
+func TestRomanNumeral(t *testing.T) {
+	cases := map[int]string{
+		0:    "",
+		1:    "I",
+		4:    "IV",
+		9:    "IX",
+		14:   "XIV",
+		40:   "XL",
+		90:   "XC",
+		400:  "CD",
+		1994: "MCMXCIV",
+		2024: "MMXXIV",
+	}
+	for number, want := range cases {
+		if got := romanNumeral(number); got != want {
+			t.Errorf("romanNumeral(%v) = %q, expected %q", number, got, want)
+		}
+	}
+}
+
+func TestLetterNumber(t *testing.T) {
+	cases := map[int]string{
+		0:  "",
+		1:  "A",
+		26: "Z",
+		27: "AA",
+		28: "BB",
+		52: "ZZ",
+		53: "AAA",
+	}
+	for number, want := range cases {
+		if got := letterNumber(number); got != want {
+			t.Errorf("letterNumber(%v) = %q, expected %q", number, got, want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/bmharper/textorient"
+)
+
+// Straighten each of docs by the angles (as returned by its PageAngles) at the same index of anglesPerDoc,
+// and concatenate the pages of all of them into a single new PDF. Each document encodes its own pages with its
+// own settings, but the placement of the pages, and the metadata of the output, follow the settings of the
+// first document. Page labels are not carried over, because the numbering of the documents would collide.
+func MergeStraightened(docs []*Document, orient *textorient.Orient, anglesPerDoc [][]float64) ([]byte, error) {
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("No documents to merge")
+	}
+	if len(docs) != len(anglesPerDoc) {
+		return nil, fmt.Errorf("Number of documents (%v) does not match number of angle lists (%v)", len(docs), len(anglesPerDoc))
+	}
+	for i, d := range docs {
+		if err := d.validatePDFOutput(); err != nil {
+			return nil, err
+		}
+		if err := d.validateRange(d.allPages(), anglesPerDoc[i]); err != nil {
+			return nil, fmt.Errorf("Document %v: %w", i, err)
+		}
+	}
+	first := docs[0]
+	builder, err := first.newPDFBuilder()
+	if err != nil {
+		return nil, err
+	}
+	blank := 0
+	for i, d := range docs {
+		pages, err := d.straightenedImages(context.Background(), orient, d.allPages(), anglesPerDoc[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pages {
+			if p.blank {
+				blank++
+				continue
+			}
+			if p.image == nil {
+				// A skipped page must be rendered from its own document, and not from the one that builds the PDF
+				if p, err = d.placeholderPage(p.page); err != nil {
+					return nil, err
+				}
+			}
+			if err := builder.addPage(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if builder.ctx.PageCount == 0 && blank != 0 {
+		return nil, ErrEveryPageBlank
+	}
+	output := &bytes.Buffer{}
+	if err := first.writeContext(builder.ctx, output); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
@@ -0,0 +1,205 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Metadata is the subset of the PDF document information dictionary that we carry through to the output
+type Metadata struct {
+	Title        string
+	Author       string
+	Subject      string
+	Keywords     string
+	Creator      string
+	CreationDate string // PDF date string, eg "D:20240131143000+02'00'"
+}
+
+// Returns a copy of m, with every non-empty field of override replacing the corresponding field of m
+func (m Metadata) merge(override Metadata) Metadata {
+	pick := func(a, b string) string {
+		if b != "" {
+			return b
+		}
+		return a
+	}
+	return Metadata{
+		Title:        pick(m.Title, override.Title),
+		Author:       pick(m.Author, override.Author),
+		Subject:      pick(m.Subject, override.Subject),
+		Keywords:     pick(m.Keywords, override.Keywords),
+		Creator:      pick(m.Creator, override.Creator),
+		CreationDate: pick(m.CreationDate, override.CreationDate),
+	}
+}
+
+// Returns the metadata of the source document
+func (d *Document) SourceMetadata() (Metadata, error) {
+	if d.reader == nil {
+		return Metadata{}, nil
+	}
+	var ctx *model.Context
+	err := d.withReader(func(r io.ReadSeeker) (err error) {
+		ctx, err = pdfapi.ReadContext(r, d.pdfcpuConfig())
+		return
+	})
+	if err != nil {
+		return Metadata{}, err
+	}
+	if ctx.Info == nil {
+		return Metadata{}, nil
+	}
+	info, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || info == nil {
+		return Metadata{}, err
+	}
+	text := func(key string) string {
+		obj, ok := info.Find(key)
+		if !ok {
+			return ""
+		}
+		s, err := ctx.DereferenceText(obj)
+		if err != nil {
+			return ""
+		}
+		return s
+	}
+	return Metadata{
+		Title:        text("Title"),
+		Author:       text("Author"),
+		Subject:      text("Subject"),
+		Keywords:     text("Keywords"),
+		Creator:      text("Creator"),
+		CreationDate: text("CreationDate"),
+	}, nil
+}
+
+// Returns the metadata that should be written to the output document
+func (d *Document) outputMetadata() (Metadata, error) {
+	md := Metadata{}
+	if d.PreserveMetadata {
+		var err error
+		if md, err = d.SourceMetadata(); err != nil {
+			return md, err
+		}
+	}
+	if d.Metadata != nil {
+		md = md.merge(*d.Metadata)
+	}
+	return md, nil
+}
+
+// Update the Info dictionary of ctx, creating it if necessary
+func setInfoDict(ctx *model.Context, md Metadata) error {
+	var info types.Dict
+	if ctx.Info != nil {
+		var err error
+		if info, err = ctx.DereferenceDict(*ctx.Info); err != nil {
+			return err
+		}
+	}
+	create := info == nil
+	if create {
+		info = types.NewDict()
+	}
+	for _, kv := range [][2]string{
+		{"Title", md.Title},
+		{"Author", md.Author},
+		{"Subject", md.Subject},
+		{"Keywords", md.Keywords},
+		{"Creator", md.Creator},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		encoded, err := types.EscapedUTF16String(kv[1])
+		if err != nil {
+			return err
+		}
+		info.Update(kv[0], types.StringLiteral(*encoded))
+	}
+	if !create || len(info) == 0 {
+		return nil
+	}
+	ir, err := ctx.IndRefForNewObject(info)
+	if err != nil {
+		return err
+	}
+	ctx.Info = ir
+	return nil
+}
+
+// Number of bytes at the end of a written PDF that pdfWriter keeps, which is plenty for "startxref", the offset, and "%%EOF"
+const pdfTailSize = 128
+
+// Passes a PDF through to w, counting its bytes, and keeping the last few, so that an incremental update
+// can be appended to it without reading it again
+type pdfWriter struct {
+	w    io.Writer
+	size int64
+	tail []byte
+}
+
+func (p *pdfWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.size += int64(n)
+	p.tail = append(p.tail, b[:n]...)
+	if len(p.tail) > pdfTailSize {
+		p.tail = append(p.tail[:0], p.tail[len(p.tail)-pdfTailSize:]...)
+	}
+	return n, err
+}
+
+// Returns the offset of the last cross reference section that was written, from its startxref line
+func (p *pdfWriter) lastXRefOffset() (int64, error) {
+	i := bytes.LastIndex(p.tail, []byte("startxref"))
+	if i < 0 {
+		return 0, fmt.Errorf("Written PDF has no startxref")
+	}
+	fields := bytes.Fields(p.tail[i+len("startxref"):])
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("Written PDF has no startxref offset")
+	}
+	return strconv.ParseInt(string(fields[0]), 10, 64)
+}
+
+// pdfcpu unconditionally stamps CreationDate with the current time when it writes a document,
+// so we write ctx to w, and then restore the original date by appending an incremental update.
+// ctx is still live after the first write, so the update is made from it, without buffering the document.
+func writeWithCreationDate(ctx *model.Context, creationDate string, w io.Writer) error {
+	pw := &pdfWriter{w: w}
+	if err := pdfapi.Write(ctx, pw, ctx.Configuration); err != nil {
+		return err
+	}
+	if ctx.Info == nil {
+		return nil
+	}
+	prevXRef, err := pw.lastXRefOffset()
+	if err != nil {
+		return err
+	}
+	info, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil {
+		return err
+	}
+	escaped, err := types.Escape(creationDate)
+	if err != nil {
+		return err
+	}
+	info.Update("CreationDate", types.StringLiteral(*escaped))
+
+	// Only the objects of the increment belong in its cross reference section
+	ctx.Write.Table = map[int]int64{}
+	ctx.Write.ObjNrs = nil
+	ctx.Write.Increment = true
+	ctx.Write.Offset = pw.size
+	ctx.Write.OffsetPrevXRef = &prevXRef
+	ctx.Write.IncrementWithObjNr(ctx.Info.ObjectNumber.Value())
+	return pdfapi.WriteIncrement(ctx, w)
+}
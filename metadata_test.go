@@ -0,0 +1,45 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+func TestBuildPDFRestoresCreationDate(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	page := straightenedPage{image: buf.Bytes(), width: 8, height: 8}
+	const creationDate = "D:20240131143000+02'00'"
+	for _, xrefStream := range []bool{false, true} {
+		d := newEmptyDocument()
+		d.NumPages = 1
+		d.Metadata = &Metadata{Title: "Scan", CreationDate: creationDate}
+		d.PDFConfiguration = model.NewDefaultConfiguration()
+		d.PDFConfiguration.WriteXRefStream = xrefStream
+		pdf, err := d.buildPDF([]straightenedPage{page})
+		if err != nil {
+			t.Fatalf("xref stream %v: %v", xrefStream, err)
+		}
+		ctx, err := pdfapi.ReadAndValidate(bytes.NewReader(pdf), model.NewDefaultConfiguration())
+		if err != nil {
+			t.Fatalf("xref stream %v: %v", xrefStream, err)
+		}
+		info, err := ctx.DereferenceDict(*ctx.Info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range map[string]string{"CreationDate": creationDate, "Title": "Scan"} {
+			obj, _ := info.Find(key)
+			if got, err := ctx.DereferenceText(obj); err != nil || got != want {
+				t.Errorf("xref stream %v: %v is %q (%v), expected %q", xrefStream, key, got, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/bmharper/cimg/v2"
+	"github.com/pdfcpu/pdfcpu/pkg/font"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// OCRWord is a word recognized by an OCREngine
+type OCRWord struct {
+	Text string
+	// Bounding box of the word in pixels, with the origin at the top left of the image
+	X, Y, Width, Height float64
+}
+
+// OCREngine recognizes the text in a page image, for example by running tesseract.
+// Recognize is called from multiple goroutines simultaneously when Document.Concurrency is greater than 1.
+type OCREngine interface {
+	Recognize(img *cimg.Image) ([]OCRWord, error)
+}
+
+// Name of the font resource of the text layer. The font is never drawn, so a standard font that doesn't
+// need to be embedded is just fine.
+const (
+	ocrFontResource = "FOCR"
+	ocrFont         = "Helvetica"
+)
+
+// Add the recognized words of p to a page produced by pdfcpu.NewPageForImage, as invisible text
+func addTextLayer(xRefTable *model.XRefTable, pageRef *types.IndirectRef, p straightenedPage) error {
+	if len(p.words) == 0 || p.width == 0 || p.height == 0 {
+		return nil
+	}
+	pageDict, err := xRefTable.DereferenceDict(*pageRef)
+	if err != nil {
+		return err
+	}
+	box, err := imagePlacement(xRefTable, pageDict)
+	if err != nil {
+		return err
+	}
+	scaleX := box.Width() / float64(p.width)
+	scaleY := box.Height() / float64(p.height)
+
+	content := &bytes.Buffer{}
+	// Text render mode 3 is invisible, which is what OCR software uses to make scans searchable.
+	// The leading newline separates us from the last token of the image content stream.
+	content.WriteString("\nBT 3 Tr\n")
+	for _, w := range p.words {
+		text := winAnsi(w.Text)
+		textWidth := font.TextWidth(text, ocrFont, 1)
+		if text == "" || textWidth <= 0 || w.Width <= 0 || w.Height <= 0 {
+			continue
+		}
+		size := w.Height * scaleY
+		// Stretch the text horizontally so that it covers the word, which is what makes text selection line up
+		stretch := 100 * w.Width * scaleX / (textWidth * size)
+		x := box.LL.X + w.X*scaleX
+		y := box.LL.Y + box.Height() - (w.Y+w.Height)*scaleY
+		fmt.Fprintf(content, "/%v %.2f Tf %.2f Tz 1 0 0 1 %.2f %.2f Tm (%v) Tj\n", ocrFontResource, size, stretch, x, y, escapePDFString(text))
+	}
+	content.WriteString("ET\n")
+
+	sd, err := xRefTable.NewStreamDictForBuf(content.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := sd.Encode(); err != nil {
+		return err
+	}
+	textRef, err := xRefTable.IndRefForNewObject(*sd)
+	if err != nil {
+		return err
+	}
+	imageContents, ok := pageDict["Contents"]
+	if !ok {
+		return fmt.Errorf("New page has no content")
+	}
+	pageDict.Update("Contents", types.Array{imageContents, *textRef})
+
+	resources, err := xRefTable.DereferenceDict(pageDict["Resources"])
+	if err != nil || resources == nil {
+		return err
+	}
+	fontDict := types.NewDict()
+	fontDict.InsertName("Type", "Font")
+	fontDict.InsertName("Subtype", "Type1")
+	fontDict.InsertName("BaseFont", ocrFont)
+	fontDict.InsertName("Encoding", "WinAnsiEncoding")
+	resources.Update("Font", types.Dict{ocrFontResource: fontDict})
+	return nil
+}
+
+// Returns the rectangle that the image occupies on a page produced by pdfcpu.NewPageForImage.
+// The image doesn't fill the page when OutputPagePosition is not types.Full, so we read the
+// transformation that NewPageForImage drew the image with.
+func imagePlacement(xRefTable *model.XRefTable, pageDict types.Dict) (*types.Rectangle, error) {
+	sd, _, err := xRefTable.DereferenceStreamDict(pageDict["Contents"])
+	if err != nil {
+		return nil, err
+	}
+	if sd == nil {
+		return nil, fmt.Errorf("New page has no content")
+	}
+	var a, b, c, dd, e, f float64
+	if _, err := fmt.Sscanf(string(sd.Content), "q %f %f %f %f %f %f cm", &a, &b, &c, &dd, &e, &f); err != nil {
+		return nil, fmt.Errorf("Unexpected content in new page: %w", err)
+	}
+	return types.NewRectangle(e, f, e+a, f+dd), nil
+}
+
+// Encode text in WinAnsiEncoding, which is the encoding of our font, replacing characters that it can't represent
+func winAnsi(text string) string {
+	encoded := make([]byte, 0, len(text))
+	for _, r := range strings.TrimSpace(text) {
+		b, ok := charmap.Windows1252.EncodeRune(r)
+		if !ok {
+			b = '?'
+		}
+		encoded = append(encoded, b)
+	}
+	return string(encoded)
+}
+
+// Escape the bytes of s for a PDF literal string
+func escapePDFString(s string) string {
+	buf := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < 32 || c > 126:
+			fmt.Fprintf(buf, "\\%03o", c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,171 @@
+package pdfstraighten
+
+import (
+	"image/color"
+
+	"github.com/bmharper/textorient"
+)
+
+// Default search range of StraightenFile and StraightenWithOptions, in degrees
+const defaultMaxAngle = 2.6
+
+// Options gathers the settings that are most often tuned, for StraightenFile, StraightenWithOptions,
+// and the NewDocument...WithOptions constructors. The zero value is a sensible default. The Document settings
+// are pointers, so that a setting that was given, even as false or zero, can be told apart from one that wasn't,
+// which keeps the value that the Document already has. Build one with NewOptions and the With... functions,
+// or fill in the fields directly. Settings that aren't here can be reached with Configure.
+type Options struct {
+	MaxAngle       float64            // Largest skew that is corrected, in degrees. Default 2.6.
+	Allow90Degrees bool               // If false, pages that are rotated by about 90 degrees are only deskewed (see NormalizeAngles)
+	Orient         *textorient.Orient // If nil, StraightenFile and StraightenWithOptions create one for the duration of the call
+	Configure      func(d *Document)  // If not nil, called to adjust the settings of the Document, after the other options
+
+	Concurrency  *int          // See Document.Concurrency
+	JPEGQuality  *int          // See Document.OutputQuality
+	OutputFormat *OutputFormat // See Document.OutputFormat
+	Grayscale    *bool         // See Document.OutputGrayscale
+	Background   color.Color   // See Document.RotateBackground
+}
+
+// Option modifies one setting of an Options
+type Option func(o *Options)
+
+// Use all of the settings of o, so that one Options value can be reused for many documents.
+// Options that follow this one override its settings.
+func WithOptions(o Options) Option {
+	return func(dst *Options) { *dst = o }
+}
+
+// Returns Options with the given options applied to the zero value
+func NewOptions(opts ...Option) Options {
+	o := Options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Largest skew that is corrected, in degrees
+func WithMaxAngle(degrees float64) Option {
+	return func(o *Options) { o.MaxAngle = degrees }
+}
+
+// Turn pages that are rotated by about 90 degrees, instead of only deskewing them
+func WithAllow90Degrees(allow bool) Option {
+	return func(o *Options) { o.Allow90Degrees = allow }
+}
+
+// Orientation detector for MakeUpright, so that it can be shared between documents
+func WithOrient(orient *textorient.Orient) Option {
+	return func(o *Options) { o.Orient = orient }
+}
+
+// Function that adjusts any other settings of the Document
+func WithConfigure(configure func(d *Document)) Option {
+	return func(o *Options) { o.Configure = configure }
+}
+
+// Number of pages to process in parallel
+func WithConcurrency(concurrency int) Option {
+	return func(o *Options) { o.Concurrency = &concurrency }
+}
+
+// JPEG quality (1..100) of straightened pages
+func WithJPEGQuality(quality int) Option {
+	return func(o *Options) { o.JPEGQuality = &quality }
+}
+
+// Encoding of straightened pages
+func WithOutputFormat(format OutputFormat) Option {
+	return func(o *Options) { o.OutputFormat = &format }
+}
+
+// Convert straightened pages to grayscale
+func WithGrayscale(grayscale bool) Option {
+	return func(o *Options) { o.Grayscale = &grayscale }
+}
+
+// Color of the regions that are uncovered by rotating a page
+func WithBackground(background color.Color) Option {
+	return func(o *Options) { o.Background = background }
+}
+
+// Returns MaxAngle, or its default if it is zero
+func (o *Options) maxAngle() float64 {
+	if o.MaxAngle == 0 {
+		return defaultMaxAngle
+	}
+	return o.MaxAngle
+}
+
+// Apply the Document settings of o to d. Settings that were not given keep the value that d already has.
+func (o *Options) configure(d *Document) {
+	if o.Concurrency != nil {
+		d.Concurrency = *o.Concurrency
+	}
+	if o.JPEGQuality != nil {
+		d.OutputQuality = *o.JPEGQuality
+	}
+	if o.OutputFormat != nil {
+		d.OutputFormat = *o.OutputFormat
+	}
+	if o.Grayscale != nil {
+		d.OutputGrayscale = *o.Grayscale
+	}
+	if o.Background != nil {
+		d.RotateBackground = o.Background
+	}
+	if o.Configure != nil {
+		o.Configure(d)
+	}
+}
+
+// Load a PDF from a file, and apply the Document settings of opts
+func NewDocumentFromFileWithOptions(filename string, opts ...Option) (*Document, error) {
+	d, err := NewDocumentFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	o := NewOptions(opts...)
+	o.configure(d)
+	return d, nil
+}
+
+// Load a PDF from bytes, and apply the Document settings of opts
+func NewDocumentFromMemoryWithOptions(doc []byte, opts ...Option) (*Document, error) {
+	d, err := NewDocumentFromMemory(doc)
+	if err != nil {
+		return nil, err
+	}
+	o := NewOptions(opts...)
+	o.configure(d)
+	return d, nil
+}
+
+// Measure the angle of every page with the MaxAngle of opts, and straighten the document.
+// The Document settings of opts only apply to this call. They are applied to a copy of the settings of d,
+// which is left unchanged.
+func (d *Document) StraightenWithOptions(opts ...Option) ([]byte, error) {
+	o := NewOptions(opts...)
+	// The copy shares the source, and the image cache, of d
+	withOptions := *d
+	o.configure(&withOptions)
+	return withOptions.straightenWithOptions(&o)
+}
+
+// Same as StraightenWithOptions, but the Document settings of o have already been applied
+func (d *Document) straightenWithOptions(o *Options) ([]byte, error) {
+	orient := o.Orient
+	if orient == nil {
+		var err error
+		if orient, err = textorient.NewOrient(); err != nil {
+			return nil, err
+		}
+		defer orient.Close()
+	}
+	angles, err := d.PageAngles(o.maxAngle(), true)
+	if err != nil {
+		return nil, err
+	}
+	return d.Straighten(orient, NormalizeAngles(angles, o.Allow90Degrees))
+}
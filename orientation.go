@@ -0,0 +1,142 @@
+package pdfstraighten
+
+import (
+	"math"
+
+	"github.com/bmharper/cimg/v2"
+	"github.com/bmharper/textorient"
+)
+
+// The page is split into orientVoteGrid x orientVoteGrid regions, each of which votes on the page orientation
+const orientVoteGrid = 2
+
+// Fraction of regions that must agree that a page is upside down, before Check180 will flip it
+const check180Agreement = 0.75
+
+// Run textorient on each region of img, and return the number of regions that voted for each
+// of textorient.Angle0, Angle90, Angle180, Angle270. If the regions are too small to classify, there are no votes.
+// textorient only tells us the orientation of a whole image, so this is how we measure its certainty.
+func orientationVotes(orient *textorient.Orient, img *cimg.Image) ([4]int, error) {
+	votes := [4]int{}
+	w := img.Width / orientVoteGrid
+	h := img.Height / orientVoteGrid
+	if w < 3*textorient.TileSize || h < 3*textorient.TileSize {
+		return votes, nil
+	}
+	for gy := range orientVoteGrid {
+		for gx := range orientVoteGrid {
+			region := referenceRegion(img, gx*w, gy*h, (gx+1)*w, (gy+1)*h)
+			o, err := orient.GetImageOrientation(region)
+			if err != nil {
+				return votes, err
+			}
+			votes[o]++
+		}
+	}
+	return votes, nil
+}
+
+// Returns true if the regions of img strongly agree that it is upside down
+func isUpsideDown(orient *textorient.Orient, img *cimg.Image) (bool, error) {
+	votes, err := orientationVotes(orient, img)
+	if err != nil {
+		return false, err
+	}
+	total := votes[0] + votes[1] + votes[2] + votes[3]
+	return total != 0 && float64(votes[textorient.Angle180]) >= check180Agreement*float64(total), nil
+}
+
+// Same as img.ReferenceCrop, which slices past the end of the pixels when the region touches the bottom
+// right corner of img
+func referenceRegion(img *cimg.Image, x1, y1, x2, y2 int) *cimg.Image {
+	return cimg.WrapImageStrided(x2-x1, y2-y1, img.Format, img.Pixels[y1*img.Stride+x1*img.NChan():], img.Stride)
+}
+
+// Equivalent to orient.MakeUpright, but records the clockwise rotation (in degrees) that was applied in
+// result.Orientation. If OrientMinConfidence is set, and too few regions of img agree with the orientation
+// of the whole image, then img is left alone, and result.OrientationSuppressed is set.
+func (d *Document) makeUpright(orient *textorient.Orient, img *cimg.Image, result *PageResult) (*cimg.Image, error) {
+	o, err := orient.GetImageOrientation(img)
+	if err != nil {
+		return nil, err
+	}
+	if o == textorient.Angle0 {
+		return img, nil
+	}
+	if (d.DisableOrient90 && (o == textorient.Angle90 || o == textorient.Angle270)) || (d.DisableOrient180 && o == textorient.Angle180) {
+		result.OrientationSuppressed = true
+		return img, nil
+	}
+	if d.OrientMinConfidence > 0 {
+		votes, err := orientationVotes(orient, img)
+		if err != nil {
+			return nil, err
+		}
+		total := votes[0] + votes[1] + votes[2] + votes[3]
+		if total != 0 {
+			result.OrientationConfidence = float64(votes[o]) / float64(total)
+		}
+		if result.OrientationConfidence < d.OrientMinConfidence {
+			result.OrientationSuppressed = true
+			return img, nil
+		}
+	}
+	// These directions match the implementation of MakeUpright
+	switch o {
+	case textorient.Angle90:
+		result.Orientation = 270
+		return rotate90(img, -1), nil
+	case textorient.Angle180:
+		result.Orientation = 180
+		return rotate180(img), nil
+	case textorient.Angle270:
+		result.Orientation = 90
+		return rotate90(img, 1), nil
+	}
+	return img, nil
+}
+
+// Make img upright with makeUpright, and then, if Check180 is set, turn it upside down if it still looks upside down
+func (d *Document) orientImage(orient *textorient.Orient, img *cimg.Image, result *PageResult) (*cimg.Image, error) {
+	upright, err := d.makeUpright(orient, img, result)
+	if err != nil {
+		return nil, err
+	}
+	if d.Check180 && !d.DisableOrient180 {
+		upsideDown, err := isUpsideDown(orient, upright)
+		if err != nil {
+			return nil, err
+		}
+		if upsideDown {
+			upright = rotate180(upright)
+			result.Flipped180 = true
+		}
+	}
+	return upright, nil
+}
+
+// Rotate img by 90 degrees clockwise if direction is 1, or counter-clockwise if direction is -1
+func rotate90(img *cimg.Image, direction float64) *cimg.Image {
+	rotated := cimg.NewImage(img.Height, img.Width, img.Format)
+	cimg.Rotate(img, rotated, direction*math.Pi/2, nil)
+	return rotated
+}
+
+// Rotate img clockwise by degrees, which must be a multiple of 90. The pixels are moved, not resampled.
+func rotateOrthogonal(img *cimg.Image, degrees float64) *cimg.Image {
+	switch ((int(math.Round(degrees)) % 360) + 360) % 360 {
+	case 90:
+		return rotate90(img, 1)
+	case 180:
+		return rotate180(img)
+	case 270:
+		return rotate90(img, -1)
+	}
+	return img
+}
+
+func rotate180(img *cimg.Image) *cimg.Image {
+	flipped := cimg.NewImage(img.Width, img.Height, img.Format)
+	cimg.Rotate(img, flipped, math.Pi, nil)
+	return flipped
+}
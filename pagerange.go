@@ -0,0 +1,128 @@
+package pdfstraighten
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bmharper/textorient"
+)
+
+// Returns the page angles (in degrees) of the given zero-based page indices.
+// The result is aligned with pages.
+func (d *Document) PageAnglesRange(pages []int, maxAngle float64, include90Degrees bool) ([]float64, error) {
+	if err := d.validatePages(pages); err != nil {
+		return nil, err
+	}
+	pageAngles, err := d.pageAngles(context.Background(), pages, maxAngle, include90Degrees)
+	if err != nil {
+		return nil, err
+	}
+	angles := make([]float64, len(pageAngles))
+	for i, pa := range pageAngles {
+		angles[i] = pa.Angle
+	}
+	return angles, nil
+}
+
+// Straighten the given zero-based page indices, and return the list of compressed images.
+// pageAngles and the result are aligned with pages.
+func (d *Document) StraightenedImagesRange(orient *textorient.Orient, pages []int, pageAngles []float64) ([][]byte, error) {
+	if err := d.validateRange(pages, pageAngles); err != nil {
+		return nil, err
+	}
+	straightPages, err := d.straightenedImages(context.Background(), orient, pages, pageAngles)
+	if err != nil {
+		return nil, err
+	}
+	return pageImages(straightPages), nil
+}
+
+// Produce a straightened PDF that contains only the given zero-based page indices, in the given order.
+// pageAngles is aligned with pages.
+func (d *Document) StraightenRange(orient *textorient.Orient, pages []int, pageAngles []float64) ([]byte, error) {
+	if err := d.validateRange(pages, pageAngles); err != nil {
+		return nil, err
+	}
+	straightPages, err := d.straightenedImages(context.Background(), orient, pages, pageAngles)
+	if err != nil {
+		return nil, err
+	}
+	return d.buildPDF(straightPages)
+}
+
+// Same as Straighten, but the output pages are in the given order, which must contain every zero-based page index
+// exactly once, or the error is ErrInvalidPageOrder. Output page i is source page order[i]. pageAngles is aligned
+// with the source pages, as usual. For example, ReversePageOrder undoes a document feeder that scans back to front.
+// Use StraightenRange to produce only some of the pages, or repeat pages.
+func (d *Document) StraightenInOrder(orient *textorient.Orient, pageAngles []float64, order []int) ([]byte, error) {
+	return d.StraightenInOrderContext(context.Background(), orient, pageAngles, order)
+}
+
+// StraightenInOrderContext is StraightenInOrder, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenInOrderContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64, order []int) ([]byte, error) {
+	if err := validateAngleCount(d.allPages(), pageAngles); err != nil {
+		return nil, err
+	}
+	if err := d.validatePageOrder(order); err != nil {
+		return nil, err
+	}
+	orderedAngles := make([]float64, len(order))
+	for i, page := range order {
+		orderedAngles[i] = pageAngles[page]
+	}
+	straightPages, err := d.straightenedImages(ctx, orient, order, orderedAngles)
+	if err != nil {
+		return nil, err
+	}
+	return d.buildPDF(straightPages)
+}
+
+// Returns the page order of StraightenInOrder that reverses a document of numPages pages
+func ReversePageOrder(numPages int) []int {
+	order := make([]int, numPages)
+	for i := range order {
+		order[i] = numPages - 1 - i
+	}
+	return order
+}
+
+// Returns ErrInvalidPageOrder unless order is a permutation of the pages of the document
+func (d *Document) validatePageOrder(order []int) error {
+	if len(order) != d.NumPages {
+		return fmt.Errorf("%w (%v pages in order, document has %v)", ErrInvalidPageOrder, len(order), d.NumPages)
+	}
+	seen := make([]bool, d.NumPages)
+	for _, page := range order {
+		if page < 0 || page >= d.NumPages {
+			return fmt.Errorf("%w (page index %v is out of range)", ErrInvalidPageOrder, page)
+		}
+		if seen[page] {
+			return fmt.Errorf("%w (page index %v appears more than once)", ErrInvalidPageOrder, page)
+		}
+		seen[page] = true
+	}
+	return nil
+}
+
+func (d *Document) validateRange(pages []int, pageAngles []float64) error {
+	if err := validateAngleCount(pages, pageAngles); err != nil {
+		return err
+	}
+	return d.validatePages(pages)
+}
+
+func validateAngleCount(pages []int, pageAngles []float64) error {
+	if len(pages) != len(pageAngles) {
+		return fmt.Errorf("%w (%v pages, %v angles)", ErrAngleCountMismatch, len(pages), len(pageAngles))
+	}
+	return nil
+}
+
+func (d *Document) validatePages(pages []int) error {
+	for _, page := range pages {
+		if page < 0 || page >= d.NumPages {
+			return newPageError(page, fmt.Errorf("%w (document has %v pages)", ErrPageOutOfRange, d.NumPages))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package pdfstraighten
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestValidatePageOrder(t *testing.T) {
+	d := newEmptyDocument()
+	d.NumPages = 3
+	cases := []struct {
+		order []int
+		valid bool
+	}{
+		{[]int{0, 1, 2}, true},
+		{[]int{2, 0, 1}, true},
+		{[]int{0, 1}, false},
+		{[]int{0, 1, 2, 0}, false},
+		{[]int{0, 1, 3}, false},
+		{[]int{-1, 0, 1}, false},
+		{[]int{0, 0, 1}, false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		err := d.validatePageOrder(c.order)
+		if c.valid && err != nil {
+			t.Errorf("%v: unexpected error %v", c.order, err)
+		} else if !c.valid && !errors.Is(err, ErrInvalidPageOrder) {
+			t.Errorf("%v: got %v, expected ErrInvalidPageOrder", c.order, err)
+		}
+	}
+}
+
+func TestReversePageOrder(t *testing.T) {
+	if got := ReversePageOrder(3); !slices.Equal(got, []int{2, 1, 0}) {
+		t.Errorf("got %v, expected [2 1 0]", got)
+	}
+	if got := ReversePageOrder(0); len(got) != 0 {
+		t.Errorf("got %v, expected no pages", got)
+	}
+}
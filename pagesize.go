@@ -0,0 +1,234 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+
+	"github.com/bmharper/cimg/v2"
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Return the resolution of img, which was extracted from the given page, by comparing
+// the pixel dimensions of the image to the physical dimensions of the page's MediaBox.
+// Returns zero if PreservePageSize is false, or the page has no usable size.
+func (d *Document) sourceDPI(page int, img *cimg.Image) (float64, error) {
+	if !d.PreservePageSize {
+		return 0, nil
+	}
+	pages, err := d.getPageInfo()
+	if err != nil {
+		return 0, err
+	}
+	if page >= len(pages) {
+		return 0, nil
+	}
+	// Compare the long edges, so that we don't need to care whether the page has a /Rotate
+	pagePoints := max(pages[page].dim.Width, pages[page].dim.Height)
+	if pagePoints <= 0 {
+		return 0, nil
+	}
+	return float64(max(img.Width, img.Height)) * 72 / pagePoints, nil
+}
+
+// Physical properties of a page in the source document
+type pageInfo struct {
+	dim    types.Dim // MediaBox dimensions in points, before rotation
+	rotate int       // The /Rotate of the page, normalized to 0, 90, 180, or 270
+}
+
+// Returns the physical properties of every page in the source document
+func (d *Document) getPageInfo() ([]pageInfo, error) {
+	d.readerLock.Lock()
+	defer d.readerLock.Unlock()
+	if d.pageInfo == nil {
+		if err := d.rewindLocked(); err != nil {
+			return nil, err
+		}
+		ctx, err := pdfapi.ReadAndValidate(d.reader, d.pdfcpuConfig())
+		if err != nil {
+			return nil, err
+		}
+		boundaries, err := ctx.PageBoundaries(nil)
+		if err != nil {
+			return nil, err
+		}
+		pages := make([]pageInfo, len(boundaries))
+		for i, pb := range boundaries {
+			pages[i] = pageInfo{
+				dim:    pb.MediaBox().Dimensions(),
+				rotate: ((pb.Rot % 360) + 360) % 360,
+			}
+		}
+		d.pageInfo = pages
+	}
+	return d.pageInfo, nil
+}
+
+// Returns the /Rotate attribute of the page, in degrees clockwise (0, 90, 180, or 270).
+// PDF viewers rotate the page by this amount when displaying it.
+func (d *Document) PageRotation(page int) (int, error) {
+	pages, err := d.getPageInfo()
+	if err != nil {
+		return 0, err
+	}
+	if page < 0 || page >= len(pages) {
+		return 0, newPageError(page, ErrPageOutOfRange)
+	}
+	return pages[page].rotate, nil
+}
+
+// Rotate img clockwise by the /Rotate of the page, so that it appears the way a PDF viewer shows it
+func (d *Document) applyPageRotation(page int, img *cimg.Image) (*cimg.Image, error) {
+	rotate, err := d.PageRotation(page)
+	if err != nil || rotate == 0 {
+		return img, err
+	}
+	var rotated *cimg.Image
+	if rotate == 180 {
+		rotated = cimg.NewImage(img.Width, img.Height, img.Format)
+	} else {
+		rotated = cimg.NewImage(img.Height, img.Width, img.Format)
+	}
+	cimg.Rotate(img, rotated, float64(rotate)*math.Pi/180, nil)
+	return rotated, nil
+}
+
+// Returns the import config for an encoded image.
+// If dpi is zero, or the image dimensions can't be determined, then the page size will match the image size (1 pixel = 1 point).
+// Otherwise, the page is sized so that the image ends up at the given resolution.
+// OutputPagePosition, OutputPageScale, and OutputPageSize override this, if they are set.
+func (d *Document) pageImportConfig(img []byte, dpi float64) *pdfcpu.Import {
+	importConfig := pdfcpu.DefaultImportConfig()
+	importConfig.Scale = 1
+	// types.Full is better than types.Center, because we get landscape/portrait pages, depending on the aspect ratio of the page.
+	// Basically, with types.Full, the page size matches the image size.
+	importConfig.Pos = types.Full
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return importConfig
+	}
+	var pageDim *types.Dim
+	if d.OutputPagePosition != types.Full && d.OutputPageSize != nil {
+		pageDim = &types.Dim{Width: d.OutputPageSize.Width, Height: d.OutputPageSize.Height}
+		if d.OutputPageMatchOrientation && (cfg.Width > cfg.Height) != (pageDim.Width > pageDim.Height) {
+			pageDim.Width, pageDim.Height = pageDim.Height, pageDim.Width
+		}
+	} else if dpi > 0 {
+		pageDim = &types.Dim{
+			Width:  float64(cfg.Width) * 72 / dpi,
+			Height: float64(cfg.Height) * 72 / dpi,
+		}
+	} else if d.OutputPagePosition != types.Full {
+		pageDim = &types.Dim{Width: float64(cfg.Width), Height: float64(cfg.Height)}
+	} else {
+		return importConfig
+	}
+	// With Scale = 1 and a page of the same aspect ratio as the image, Center fills the whole page
+	importConfig.Pos = types.Center
+	if d.OutputPagePosition != types.Full {
+		importConfig.Pos = d.OutputPagePosition
+		if d.OutputPageScale > 0 && d.OutputPageScale <= 1 {
+			importConfig.Scale = d.OutputPageScale
+		}
+	}
+	importConfig.PageDim = pageDim
+	importConfig.UserDim = true
+	return importConfig
+}
+
+// Pad img with the background color, keeping it centered, so that it has the aspect ratio of the page.
+// The long side of the image is matched to the long side of the page, so that a page that was turned
+// upright keeps its orientation. Returns img itself if the aspect ratios already agree to within a pixel,
+// or if the page size is unknown.
+func (d *Document) padToPageAspect(page int, img *cimg.Image) (*cimg.Image, error) {
+	pages, err := d.getPageInfo()
+	if err != nil {
+		return nil, err
+	}
+	if page >= len(pages) {
+		return img, nil
+	}
+	pageLong := max(pages[page].dim.Width, pages[page].dim.Height)
+	pageShort := min(pages[page].dim.Width, pages[page].dim.Height)
+	if pageShort <= 0 {
+		return img, nil
+	}
+	pageAspect := pageLong / pageShort
+	imgLong := max(img.Width, img.Height)
+	imgShort := min(img.Width, img.Height)
+	newLong, newShort := imgLong, imgShort
+	if float64(imgLong)/float64(imgShort) > pageAspect {
+		newShort = int(math.Round(float64(imgLong) / pageAspect))
+	} else {
+		newLong = int(math.Round(float64(imgShort) * pageAspect))
+	}
+	if newLong-imgLong <= 1 && newShort-imgShort <= 1 {
+		return img, nil
+	}
+	background := d.RotateBackground
+	if background == nil {
+		background = color.White
+	}
+	fill, ok := pixelBytes(img.Format, background)
+	if !ok {
+		return img, nil
+	}
+	width, height := newShort, newLong
+	if img.Width > img.Height {
+		width, height = newLong, newShort
+	}
+	d.verbose("page %v: padding %v x %v image to %v x %v to match the page\n", page+1, img.Width, img.Height, width, height)
+	padded := cimg.NewImage(width, height, img.Format)
+	nchan := padded.NChan()
+	for y := range height {
+		row := padded.Pixels[y*padded.Stride:]
+		for x := range width {
+			copy(row[x*nchan:], fill)
+		}
+	}
+	if err := padded.CopyImage(img, (width-img.Width)/2, (height-img.Height)/2); err != nil {
+		return nil, err
+	}
+	return padded, nil
+}
+
+// Returns the size of a page as a viewer displays it, in points, after applying its /Rotate
+func displayedSize(dim types.Dim, rotate int) types.Dim {
+	if rotate%180 != 0 {
+		return types.Dim{Width: dim.Height, Height: dim.Width}
+	}
+	return dim
+}
+
+// Returns the displayed size of every page in the source document
+func (d *Document) sourcePageSizes() ([]types.Dim, error) {
+	pages, err := d.getPageInfo()
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]types.Dim, len(pages))
+	for i, p := range pages {
+		sizes[i] = displayedSize(p.dim, p.rotate)
+	}
+	return sizes, nil
+}
+
+// Returns the displayed size of every page in ctx, which is a document that we're about to write
+func displayedPageSizes(ctx *model.Context) ([]types.Dim, error) {
+	boundaries, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]types.Dim, len(boundaries))
+	for i, pb := range boundaries {
+		sizes[i] = displayedSize(pb.MediaBox().Dimensions(), ((pb.Rot%360)+360)%360)
+	}
+	return sizes, nil
+}
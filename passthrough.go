@@ -0,0 +1,118 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"io"
+
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Returns true if pages is every page of the document, in order
+func isWholeDocument(pages []straightenedPage, numPages int) bool {
+	if len(pages) != numPages {
+		return false
+	}
+	for i, p := range pages {
+		if p.page != i {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if pages is the whole source PDF, and none of them need to change
+func (d *Document) isUnchangedDocument(pages []straightenedPage) bool {
+	if d.reader == nil || !isWholeDocument(pages, d.NumPages) || !d.PreserveMetadata || d.Metadata != nil || d.hasCustomPageLayout() {
+		return false
+	}
+	for _, p := range pages {
+		if p.modified || p.blank || len(p.words) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if any setting asks for output pages that are laid out differently from the source pages,
+// which the source PDF can't satisfy
+func (d *Document) hasCustomPageLayout() bool {
+	return !d.PreservePageSize || d.OutputPagePosition != types.Full || d.OutputPageScale != 1 || d.OutputPageSize != nil
+}
+
+// Copy the source PDF to w, untouched
+func (d *Document) writeSourcePDF(w io.Writer) error {
+	return d.withReader(func(r io.ReadSeeker) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// Page attributes that describe the geometry of the original page, which no longer apply once
+// we've replaced its content with a straightened image.
+var replacedPageGeometry = []string{"CropBox", "BleedBox", "TrimBox", "ArtBox", "UserUnit"}
+
+// Make a copy of the source document, in which only the modified pages have been replaced.
+// Unmodified pages keep all of their original objects (content, fonts, annotations, etc).
+func (d *Document) modifiedPDFContext(pages []straightenedPage) (*model.Context, error) {
+	var ctx *model.Context
+	err := d.withReader(func(r io.ReadSeeker) (err error) {
+		ctx, err = pdfapi.ReadAndValidate(r, d.pdfcpuConfig())
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pages {
+		if !p.modified {
+			continue
+		}
+		pageDict, _, _, err := ctx.PageDict(p.page+1, false)
+		if err != nil {
+			return nil, err
+		}
+		// Build a new page for the image, and then move its content into the original page dict,
+		// so that the page tree, and anything that refers to the page (eg outlines), is untouched.
+		// The new page itself is left unreferenced, so it is not written.
+		parent := pageDict.IndirectRefEntry("Parent")
+		newPageRef, err := pdfcpu.NewPageForImage(ctx.XRefTable, bytes.NewReader(p.image), parent, d.pageImportConfig(p.image, p.dpi))
+		if err != nil {
+			return nil, err
+		}
+		if err := packBilevelImage(ctx.XRefTable, newPageRef, p.image); err != nil {
+			return nil, err
+		}
+		if err := addTextLayer(ctx.XRefTable, newPageRef, p); err != nil {
+			return nil, err
+		}
+		newPage, err := ctx.DereferenceDict(*newPageRef)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range []string{"Resources", "Contents", "MediaBox"} {
+			pageDict.Update(key, newPage[key])
+		}
+		for _, key := range replacedPageGeometry {
+			pageDict.Delete(key)
+		}
+		// The image is already upright, so don't let an inherited /Rotate turn it again
+		pageDict.Update("Rotate", types.Integer(0))
+	}
+	// Remove pages from the back, so that the page numbers of the remaining blank pages don't change
+	for i := len(pages) - 1; i >= 0; i-- {
+		if pages[i].blank {
+			if err := removePage(ctx, pages[i].page+1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !d.PreserveMetadata {
+		ctx.Info = nil
+	}
+	if err := d.writePageLabels(ctx, pages); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
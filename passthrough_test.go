@@ -0,0 +1,27 @@
+package pdfstraighten
+
+import (
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func TestHasCustomPageLayout(t *testing.T) {
+	a4 := types.PaperSize["A4"]
+	cases := map[string]func(d *Document){
+		"PreservePageSize":   func(d *Document) { d.PreservePageSize = false },
+		"OutputPagePosition": func(d *Document) { d.OutputPagePosition = types.Center },
+		"OutputPageScale":    func(d *Document) { d.OutputPageScale = 0.9 },
+		"OutputPageSize":     func(d *Document) { d.OutputPageSize = a4 },
+	}
+	if newEmptyDocument().hasCustomPageLayout() {
+		t.Errorf("the default settings have a custom page layout")
+	}
+	for name, change := range cases {
+		d := newEmptyDocument()
+		change(d)
+		if !d.hasCustomPageLayout() {
+			t.Errorf("changing %v doesn't make a custom page layout", name)
+		}
+	}
+}
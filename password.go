@@ -0,0 +1,34 @@
+package pdfstraighten
+
+import (
+	"bytes"
+	"os"
+
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Load an encrypted PDF from a file.
+// password may be either the user or the owner password.
+func NewDocumentFromFileWithPassword(filename, password string) (*Document, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewDocumentFromMemoryWithPassword(raw, password)
+}
+
+// Load an encrypted PDF from bytes.
+// password may be either the user or the owner password.
+// go-fitz has no way of supplying a password, so we decrypt the entire document into memory with
+// pdfcpu, and then open the decrypted copy with both libraries.
+func NewDocumentFromMemoryWithPassword(doc []byte, password string) (*Document, error) {
+	conf := model.NewDefaultConfiguration()
+	conf.UserPW = password
+	conf.OwnerPW = password
+	decrypted := &bytes.Buffer{}
+	if err := pdfapi.Decrypt(bytes.NewReader(doc), decrypted, conf); err != nil {
+		return nil, err
+	}
+	return NewDocumentFromMemory(decrypted.Bytes())
+}
@@ -0,0 +1,108 @@
+package pdfstraighten
+
+import (
+	"context"
+	"time"
+
+	"github.com/bmharper/cimg/v2"
+	"github.com/bmharper/textorient"
+)
+
+// PageProfile is the time spent on each stage of processing a page, as measured when Document.Profile is set
+type PageProfile struct {
+	Page         int           // Zero-based page index
+	Decode       time.Duration // Extracting and decoding the page image (almost nothing if it was in the image cache)
+	Detect       time.Duration // Detecting the angle. Only measured by StraightenOnePassWithProfile.
+	Transform    time.Duration // Rotating, turning upright, cropping, and padding
+	Encode       time.Duration // Encoding the straightened image
+	OCR          time.Duration // Recognizing text, if Document.OCR is set
+	DecodedBytes int           // Size of the decoded page image in memory
+}
+
+// Profile is the time and memory spent on a whole run, as measured when Document.Profile is set.
+// A page that was skipped (see OnPageError and StraightenScannedOnly) has nothing but its Page.
+type Profile struct {
+	Pages            []PageProfile
+	Build            time.Duration // Building the output PDF
+	Total            time.Duration // Wall clock time of the whole call. With Concurrency, this is less than the sum of the pages.
+	PeakDecodedBytes int           // Largest DecodedBytes of any page
+}
+
+// Same as Straighten, but also returns a Profile of the run, or nil if Profile is false.
+// The angles were detected earlier, by PageAngles, so Detect is zero.
+func (d *Document) StraightenWithProfile(orient *textorient.Orient, pageAngles []float64) ([]byte, *Profile, error) {
+	return d.StraightenWithProfileContext(context.Background(), orient, pageAngles)
+}
+
+// StraightenWithProfileContext is StraightenWithProfile, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenWithProfileContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64) ([]byte, *Profile, error) {
+	start := d.profileStart()
+	straightPages, err := d.straightenedImages(ctx, orient, d.allPages(), pageAngles)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.buildProfiledPDF(straightPages, start)
+}
+
+// Same as StraightenOnePass, but also returns a Profile of the run, or nil if Profile is false
+func (d *Document) StraightenOnePassWithProfile(orient *textorient.Orient, maxAngle float64, include90Degrees bool) ([]byte, *Profile, error) {
+	return d.StraightenOnePassWithProfileContext(context.Background(), orient, maxAngle, include90Degrees)
+}
+
+// StraightenOnePassWithProfileContext is StraightenOnePassWithProfile, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenOnePassWithProfileContext(ctx context.Context, orient *textorient.Orient, maxAngle float64, include90Degrees bool) ([]byte, *Profile, error) {
+	start := d.profileStart()
+	straightPages, _, err := d.straightenOnePass(ctx, orient, maxAngle, include90Degrees)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.buildProfiledPDF(straightPages, start)
+}
+
+// Build the output PDF, and the Profile of a run that began at start
+func (d *Document) buildProfiledPDF(pages []straightenedPage, start time.Time) ([]byte, *Profile, error) {
+	buildStart := d.profileStart()
+	pdf, err := d.buildPDF(pages)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !d.Profile {
+		return pdf, nil, nil
+	}
+	profile := &Profile{
+		Pages: make([]PageProfile, len(pages)),
+		Build: time.Since(buildStart),
+		Total: time.Since(start),
+	}
+	for i, p := range pages {
+		profile.Pages[i] = p.profile
+		profile.Pages[i].Page = p.page
+		profile.PeakDecodedBytes = max(profile.PeakDecodedBytes, p.profile.DecodedBytes)
+	}
+	return pdf, profile, nil
+}
+
+// Returns the current time if Profile is set, so that nothing is measured otherwise
+func (d *Document) profileStart() time.Time {
+	if !d.Profile {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// Returns the time since start, which came from profileStart, or zero if Profile is not set
+func (d *Document) profileSince(start time.Time) time.Duration {
+	if !d.Profile {
+		return 0
+	}
+	return time.Since(start)
+}
+
+// Record the decoding of img, which took decode, in the profile of p
+func (d *Document) profileDecode(p *straightenedPage, img *cimg.Image, decode time.Duration) {
+	if !d.Profile {
+		return
+	}
+	p.profile.Decode = decode
+	p.profile.DecodedBytes = len(img.Pixels)
+}
@@ -0,0 +1,54 @@
+package pdfstraighten
+
+import (
+	"math"
+
+	"github.com/bmharper/cimg/v2"
+)
+
+// Returns the variance of the horizontal projection profile of img, which is the darkness of each row
+// (or of each column, for a page that is on its side).
+// Lines of text that are level produce sharp peaks and troughs in the profile, and thus a high variance,
+// while skewed lines smear into each other. The image is downscaled like it is for angle detection.
+func profileVariance(img *cimg.Image, maxDimension int, columns bool) float64 {
+	gray := makeDocAngleImage(img, maxDimension)
+	if gray.Width == 0 || gray.Height == 0 {
+		return 0
+	}
+	n, length := gray.Height, gray.Width
+	if columns {
+		n, length = length, n
+	}
+	profile := make([]float64, n)
+	for y := range gray.Height {
+		for x, v := range gray.Pixels[y*gray.Width : (y+1)*gray.Width] {
+			if columns {
+				profile[x] += float64(255 - int(v))
+			} else {
+				profile[y] += float64(255 - int(v))
+			}
+		}
+	}
+	mean := 0.0
+	for i := range profile {
+		profile[i] /= float64(length * 255)
+		mean += profile[i]
+	}
+	mean /= float64(n)
+	variance := 0.0
+	for _, p := range profile {
+		variance += (p - mean) * (p - mean)
+	}
+	return variance / float64(n)
+}
+
+// Measure how much rotating img by angle (into fixed) sharpened its projection profile, and store it in result
+func (d *Document) measureDeskewQuality(img, fixed *cimg.Image, angle float64, result *PageResult) {
+	// If the rotation turned the page on its side, then the rows of fixed were the columns of img
+	quarterTurns := int(math.Round((angle - skewOnly(angle)) / 90))
+	result.ProfileVarianceBefore = profileVariance(img, d.DetectMaxDimension, quarterTurns%2 != 0)
+	result.ProfileVarianceAfter = profileVariance(fixed, d.DetectMaxDimension, false)
+	if result.ProfileVarianceBefore > 0 {
+		result.DeskewImprovement = result.ProfileVarianceAfter/result.ProfileVarianceBefore - 1
+	}
+}
@@ -0,0 +1,27 @@
+package pdfstraighten
+
+// Returns the image of a page exactly as it is embedded in the PDF, along with its MIME type (eg "image/jpeg"),
+// so that the original can be archived alongside the straightened page. Unlike PageImage, none of ApplyPageRotation,
+// ApplyExifOrientation, ConcatenateImageStrips, CompositeImages or RenderFallback apply. If the page has more than
+// one image, this is the largest, which is the one that Straighten uses. Fails with ErrNoImageOnPage if the page
+// has no image that pdfcpu can extract (eg JBIG2), and with ErrNotPDF for a TIFF document, which has no embedded images.
+func (d *Document) PageRawImage(page int) ([]byte, string, error) {
+	if err := d.validatePages([]int{page}); err != nil {
+		return nil, "", err
+	}
+	if d.tiff != nil {
+		return nil, "", newPageError(page, ErrNotPDF)
+	}
+	images, err := d.extractPageImages(page)
+	if err != nil {
+		return nil, "", err
+	}
+	raw, err := largestImage(images)
+	if err != nil {
+		return nil, "", err
+	}
+	if raw == nil {
+		return nil, "", newPageError(page, ErrNoImageOnPage)
+	}
+	return raw, imageFormatTypes[sniffFormat(raw)].mimeType, nil
+}
@@ -0,0 +1,46 @@
+package pdfstraighten
+
+import (
+	"io"
+
+	"github.com/gen2brain/go-fitz"
+)
+
+// Seek the underlying PDF reader back to the start of the document.
+// pdfcpu seeks around in the reader, and every operation of Document rewinds it before use, so this
+// is only needed if you share the reader that the Document was created with.
+func (d *Document) Rewind() error {
+	d.readerLock.Lock()
+	defer d.readerLock.Unlock()
+	return d.rewindLocked()
+}
+
+// Same as Rewind, but the caller must hold readerLock
+func (d *Document) rewindLocked() error {
+	if d.reader == nil {
+		return nil
+	}
+	_, err := d.reader.Seek(0, io.SeekStart)
+	return err
+}
+
+// Run fn with exclusive access to the reader, after rewinding it to the start of the document
+func (d *Document) withReader(fn func(r io.ReadSeeker) error) error {
+	d.readerLock.Lock()
+	defer d.readerLock.Unlock()
+	if err := d.rewindLocked(); err != nil {
+		return err
+	}
+	return fn(d.reader)
+}
+
+// Run fn with the go-fitz document, holding readerLock, so that Close can't free the document while fn uses it.
+// Fails with ErrNotPDF if there is no go-fitz document (eg a TIFF file, or a closed Document).
+func (d *Document) withFitz(fn func(fz *fitz.Document) error) error {
+	d.readerLock.Lock()
+	defer d.readerLock.Unlock()
+	if d.fz == nil {
+		return ErrNotPDF
+	}
+	return fn(d.fz)
+}
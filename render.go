@@ -0,0 +1,135 @@
+package pdfstraighten
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/bmharper/cimg/v2"
+	"github.com/gen2brain/go-fitz"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Upper limit on the resolution at which we'll render a page, to avoid pathological memory usage
+const maxRenderDPI = 600
+
+// Upper limit on the number of pixels in a rendered page. A page of this size occupies 400 MB as RGBA.
+const maxRenderPixels = 100_000_000
+
+// Render the whole page with go-fitz, at the given resolution.
+// Like getImageOnPage, the raw image is nil, because the image did not come directly from the PDF.
+func (d *Document) renderPage(pageIdx int, dpi float64) ([]byte, *cimg.Image, error) {
+	if d.fz == nil {
+		return nil, nil, newPageError(pageIdx, ErrNotPDF)
+	}
+	if dpi <= 0 {
+		return nil, nil, newPageError(pageIdx, fmt.Errorf("Invalid render resolution %v DPI", dpi))
+	}
+	pages, err := d.getPageInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	if pageIdx < len(pages) {
+		pixels := (pages[pageIdx].dim.Width * dpi / 72) * (pages[pageIdx].dim.Height * dpi / 72)
+		if pixels > maxRenderPixels || (d.MaxDecodedPixels > 0 && pixels > float64(d.MaxDecodedPixels)) {
+			return nil, nil, newPageError(pageIdx, fmt.Errorf("%w (%.0f megapixels at %v DPI)", ErrRenderTooLarge, pixels/1e6, dpi))
+		}
+	}
+	var rgba *image.RGBA
+	err = d.withFitz(func(fz *fitz.Document) (err error) {
+		rgba, err = fz.ImageDPI(pageIdx, dpi)
+		return
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := cimg.FromImage(rgba, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Pages are opaque, so there's nothing to be gained from keeping the alpha channel
+	return nil, img.ToRGB(), nil
+}
+
+// Returns the resolution of an image of the given pixel size, if it were stretched over the whole page,
+// or zero if the page size is unknown.
+func (d *Document) imageDPI(pageIdx, width, height int) (float64, error) {
+	pages, err := d.getPageInfo()
+	if err != nil {
+		return 0, err
+	}
+	if pageIdx >= len(pages) {
+		return 0, nil
+	}
+	pagePoints := max(pages[pageIdx].dim.Width, pages[pageIdx].dim.Height)
+	if pagePoints <= 0 {
+		return 0, nil
+	}
+	return float64(max(width, height)) * 72 / pagePoints, nil
+}
+
+// Called instead of decoding a page image of width x height pixels, which exceeds MaxDecodedPixels.
+// If DownscaleOversizedImages is set, render the page at a resolution that fits within the limit.
+// Otherwise, fail with ErrImageTooLarge.
+func (d *Document) oversizedImage(pageIdx, width, height int) ([]byte, *cimg.Image, error) {
+	tooLarge := newPageError(pageIdx, fmt.Errorf("%w (%v x %v pixels)", ErrImageTooLarge, width, height))
+	if !d.DownscaleOversizedImages || d.fz == nil {
+		return nil, nil, tooLarge
+	}
+	pages, err := d.getPageInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	if pageIdx >= len(pages) || pages[pageIdx].dim.Width <= 0 || pages[pageIdx].dim.Height <= 0 {
+		return nil, nil, tooLarge
+	}
+	// Round down, so that renderPage doesn't find the rendering a fraction of a pixel too large
+	area := pages[pageIdx].dim.Width * pages[pageIdx].dim.Height
+	dpi := math.Floor(72 * math.Sqrt(float64(d.MaxDecodedPixels)/area))
+	if dpi < 1 {
+		return nil, nil, tooLarge
+	}
+	d.verbose("page %v: image is %v x %v pixels, rendering the page at %v DPI instead\n", pageIdx+1, width, height, dpi)
+	return d.renderPage(pageIdx, dpi)
+}
+
+// If RenderFallback is set, render the whole page, because it has no image that we can use.
+// Otherwise, return cause, which is the error that we got from the regular image extraction path.
+func (d *Document) renderFallbackPage(pageIdx int, cause error) ([]byte, *cimg.Image, error) {
+	if !d.RenderFallback || d.fz == nil {
+		return nil, nil, cause
+	}
+	d.verbose("page %v: no usable image, rendering the whole page\n", pageIdx+1)
+	return d.renderPage(pageIdx, float64(d.RenderDPI))
+}
+
+// Returns a rendering of a page that was skipped because of an error, so that it's not missing from the output
+func (d *Document) placeholderPage(pageIdx int) (straightenedPage, error) {
+	_, img, err := d.renderPage(pageIdx, float64(d.RenderDPI))
+	if err != nil {
+		return straightenedPage{}, err
+	}
+	encoded, err := d.encodeImage(img, nil)
+	if err != nil {
+		return straightenedPage{}, err
+	}
+	return straightenedPage{page: pageIdx, image: encoded, dpi: float64(d.RenderDPI), modified: true}, nil
+}
+
+// Render all of the images on a page into a single image, in their PDF placement positions.
+// The page is rendered at the resolution of its highest resolution image, so that we don't lose detail.
+func (d *Document) compositePageImages(pageIdx int, images map[int]model.Image) ([]byte, *cimg.Image, error) {
+	dpi := 0.0
+	for _, img := range images {
+		imgDPI, err := d.imageDPI(pageIdx, img.Width, img.Height)
+		if err != nil {
+			return nil, nil, err
+		}
+		dpi = max(dpi, imgDPI)
+	}
+	if dpi == 0 {
+		dpi = 300
+	}
+	dpi = min(dpi, maxRenderDPI)
+	return d.renderPage(pageIdx, dpi)
+}
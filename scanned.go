@@ -0,0 +1,193 @@
+package pdfstraighten
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gen2brain/go-fitz"
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// ScanReason explains the outcome of IsScannedDetailed
+type ScanReason int
+
+const (
+	ScanReasonScanned        ScanReason = iota // A single large image per page, with no text
+	ScanReasonNoImage                          // A page has no images
+	ScanReasonMultipleImages                   // A page has more than one image (and neither CompositeImages nor ConcatenateImageStrips applies)
+	ScanReasonImageTooSmall                    // A page's image is smaller than MinScanPixels
+	ScanReasonHasText                          // Text was extracted from a page
+)
+
+func (r ScanReason) String() string {
+	switch r {
+	case ScanReasonScanned:
+		return "scanned"
+	case ScanReasonNoImage:
+		return "no image"
+	case ScanReasonMultipleImages:
+		return "multiple images"
+	case ScanReasonImageTooSmall:
+		return "image too small"
+	case ScanReasonHasText:
+		return "has text"
+	}
+	return fmt.Sprintf("ScanReason(%d)", int(r))
+}
+
+// ScanResult is the detailed outcome of IsScannedDetailed
+type ScanResult struct {
+	Scanned bool
+	Reason  ScanReason
+	Page    int // Zero-based index of the page that disqualified the document, or -1 if Scanned is true
+}
+
+// Returns true if this PDF is a scanned document
+func (d *Document) IsScanned() (bool, error) {
+	result, err := d.IsScannedDetailed()
+	return result.Scanned, err
+}
+
+// Same as IsScanned, but if the document is not scanned, explain why.
+// If MinScannedFraction is set, Page is the first page that is not scanned.
+// Pages are classified one at a time, and classification stops as soon as the outcome is certain,
+// so a document that isn't a scan is usually rejected after looking at its first page.
+func (d *Document) IsScannedDetailed() (ScanResult, error) {
+	classify, err := d.pageScanClassifier()
+	if err != nil {
+		return ScanResult{Page: -1}, err
+	}
+	failed := 0
+	first := -1
+	var firstReason ScanReason
+	for i := range d.NumPages {
+		reason, err := classify(i)
+		if err != nil {
+			return ScanResult{Page: -1}, err
+		}
+		if reason == ScanReasonScanned {
+			continue
+		}
+		failed++
+		if first == -1 {
+			first = i
+			firstReason = reason
+		}
+		if d.MinScannedFraction <= 0 || float64(d.NumPages-failed) < d.MinScannedFraction*float64(d.NumPages) {
+			return ScanResult{Reason: firstReason, Page: first}, nil
+		}
+	}
+	return ScanResult{Scanned: true, Reason: ScanReasonScanned, Page: -1}, nil
+}
+
+// Classify every page of the document. A page is ScanReasonScanned if it is a single large image with no text,
+// and otherwise the reason is the first criterion that it fails.
+func (d *Document) PageScanReasons() ([]ScanReason, error) {
+	classify, err := d.pageScanClassifier()
+	if err != nil {
+		return nil, err
+	}
+	reasons := make([]ScanReason, d.NumPages)
+	for i := range reasons {
+		if reasons[i], err = classify(i); err != nil {
+			return nil, err
+		}
+	}
+	return reasons, nil
+}
+
+// Returns a function that classifies a single page, as described by PageScanReasons.
+// The document is parsed by pdfcpu once, up front, so that classifying each page is cheap.
+func (d *Document) pageScanClassifier() (func(page int) (ScanReason, error), error) {
+	// pdfcpu is not able to extract the text from the document, which is why we use
+	// go-fitz for this. Checking that there is 1 image per page is not sufficient,
+	// because what if a document has exactly one logo image per page, and the logo
+	// happens to be quite high resolution, mimicking a scanned page.
+	// However, it is a necessary condition that there be precisely one image per page.
+
+	// Every page of a TIFF file is an image
+	if d.tiff != nil {
+		return func(page int) (ScanReason, error) {
+			return ScanReasonScanned, nil
+		}, nil
+	}
+
+	conf := d.pdfcpuConfig()
+	conf.Cmd = model.LISTIMAGES
+	var ctx *model.Context
+	err := d.withReader(func(r io.ReadSeeker) (err error) {
+		ctx, err = pdfapi.ReadValidateAndOptimize(r, conf)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return func(page int) (ScanReason, error) {
+		// Only list the images (stub is true), rather than extracting them
+		images, err := pdfcpu.ExtractPageImages(ctx, page+1, true)
+		if err != nil {
+			return 0, newPageError(page, err)
+		}
+		if reason := d.imageScanReason(images); reason != ScanReasonScanned {
+			return reason, nil
+		}
+		var txt string
+		err = d.withFitz(func(fz *fitz.Document) (err error) {
+			txt, err = fz.Text(page)
+			return
+		})
+		if err != nil {
+			return 0, newPageError(page, err)
+		}
+		if txt != "" {
+			return ScanReasonHasText, nil
+		}
+		return ScanReasonScanned, nil
+	}, nil
+}
+
+// Classify a page by its images alone
+func (d *Document) imageScanReason(imagesOnPage map[int]model.Image) ScanReason {
+	if len(imagesOnPage) == 0 {
+		if d.RenderFallback {
+			return ScanReasonScanned
+		}
+		return ScanReasonNoImage
+	}
+	if len(imagesOnPage) > 1 && !d.CompositeImages && !(d.ConcatenateImageStrips && isImageStrips(imagesOnPage)) {
+		return ScanReasonMultipleImages
+	}
+	// go-fitz sometimes fails to extract text, so we need this criteria as a fallback for documents
+	// with one little logo image on every page, and some text.
+	// When compositing or concatenating strips, it's the combined size of the images that must be large.
+	pixels := 0
+	for _, img := range imagesOnPage {
+		pixels += img.Width * img.Height
+	}
+	if pixels < d.MinScanPixels {
+		return ScanReasonImageTooSmall
+	}
+	return ScanReasonScanned
+}
+
+// If StraightenScannedOnly is set, returns true for each page that is not scanned. Otherwise, returns nil.
+func (d *Document) unscannedPages() ([]bool, error) {
+	if !d.StraightenScannedOnly {
+		return nil, nil
+	}
+	reasons, err := d.PageScanReasons()
+	if err != nil {
+		return nil, err
+	}
+	unscanned := make([]bool, len(reasons))
+	for i, reason := range reasons {
+		if reason != ScanReasonScanned {
+			d.verbose("page %v: not scanned (%v), leaving it alone\n", i+1, reason)
+			unscanned[i] = true
+		}
+	}
+	return unscanned, nil
+}
@@ -0,0 +1,55 @@
+package pdfstraighten
+
+import (
+	"math"
+	"slices"
+)
+
+// Default of SmoothAnglesOutlierDegrees. This is a little more than the step of docangle's search,
+// so that the page to page wobble of the detector counts as an outlier, but a tie between neighbouring steps doesn't.
+const defaultSmoothOutlierDegrees = 0.15
+
+// Snap the skew of each page whose skew differs from its neighbours by more than outlierDegrees to the median
+// skew of its neighbours. The neighbours of a page are the window pages centered on it, or every page, if window
+// is less than 2. Pages with zero confidence, such as pages that were skipped, don't count towards the median,
+// and are left untouched, so that a skipped page doesn't gain an angle.
+// Quarter turns are left alone, so that a page fed in sideways keeps its orientation.
+// Returns a new slice, aligned with angles.
+func smoothAngles(angles []PageAngle, window int, outlierDegrees float64) []PageAngle {
+	smoothed := slices.Clone(angles)
+	if window < 2 {
+		window = len(angles)
+	}
+	for i, a := range angles {
+		if a.Confidence <= 0 {
+			continue
+		}
+		lo := max(0, i-window/2)
+		hi := min(len(angles), lo+window)
+		lo = max(0, hi-window)
+		skews := []float64{}
+		for _, n := range angles[lo:hi] {
+			if n.Confidence > 0 {
+				skews = append(skews, n.Skew)
+			}
+		}
+		if len(skews) == 0 {
+			continue
+		}
+		consensus := median(skews)
+		if math.Abs(a.Skew-consensus) <= outlierDegrees {
+			continue
+		}
+		smoothed[i] = newPageAngle(a.Angle-a.Skew+consensus, a.Confidence)
+	}
+	return smoothed
+}
+
+// Returns the median of values, which must not be empty. values is sorted in place.
+func median(values []float64) float64 {
+	slices.Sort(values)
+	if len(values)%2 == 1 {
+		return values[len(values)/2]
+	}
+	return (values[len(values)/2-1] + values[len(values)/2]) / 2
+}
@@ -0,0 +1,68 @@
+package pdfstraighten
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSmoothAngles(t *testing.T) {
+	cases := []struct {
+		name   string
+		angles []PageAngle
+		window int
+		want   []float64 // Angle of each page after smoothing
+	}{
+		{
+			name:   "outlier is snapped to the median",
+			angles: []PageAngle{newPageAngle(0.5, 1), newPageAngle(0.5, 1), newPageAngle(1.5, 1), newPageAngle(0.5, 1)},
+			want:   []float64{0.5, 0.5, 0.5, 0.5},
+		},
+		{
+			name:   "wobble within the threshold is left alone",
+			angles: []PageAngle{newPageAngle(0.5, 1), newPageAngle(0.6, 1), newPageAngle(0.5, 1)},
+			want:   []float64{0.5, 0.6, 0.5},
+		},
+		{
+			name:   "zero confidence pages are left alone",
+			angles: []PageAngle{newPageAngle(0.5, 1), {}, newPageAngle(3, 0), newPageAngle(0.5, 1)},
+			want:   []float64{0.5, 0, 3, 0.5},
+		},
+		{
+			name:   "quarter turns are kept",
+			angles: []PageAngle{newPageAngle(0.5, 1), newPageAngle(90.9, 1), newPageAngle(0.5, 1)},
+			want:   []float64{0.5, 90.5, 0.5},
+		},
+		{
+			name:   "window limits the neighbours",
+			angles: []PageAngle{newPageAngle(2, 1), newPageAngle(2, 1), newPageAngle(2, 1), newPageAngle(0, 1), newPageAngle(0, 1), newPageAngle(0, 1)},
+			window: 3,
+			want:   []float64{2, 2, 2, 0, 0, 0},
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, c := range cases {
+		smoothed := smoothAngles(c.angles, c.window, defaultSmoothOutlierDegrees)
+		if len(smoothed) != len(c.want) {
+			t.Errorf("%v: got %v angles, expected %v", c.name, len(smoothed), len(c.want))
+			continue
+		}
+		for i, a := range smoothed {
+			if math.Abs(a.Angle-c.want[i]) > 1e-9 {
+				t.Errorf("%v: page %v has angle %v, expected %v", c.name, i, a.Angle, c.want[i])
+			}
+			if a.Confidence != c.angles[i].Confidence {
+				t.Errorf("%v: page %v has confidence %v, expected %v", c.name, i, a.Confidence, c.angles[i].Confidence)
+			}
+		}
+	}
+}
+
+func TestSmoothAnglesDoesNotModifyInput(t *testing.T) {
+	angles := []PageAngle{newPageAngle(0.5, 1), newPageAngle(2, 1), newPageAngle(0.5, 1)}
+	smoothAngles(angles, 0, defaultSmoothOutlierDegrees)
+	if angles[1].Angle != 2 {
+		t.Errorf("input was modified")
+	}
+}
@@ -0,0 +1,88 @@
+package pdfstraighten
+
+import (
+	"math"
+	"slices"
+)
+
+// Number of bins in AngleSummary.Histogram
+const angleHistogramBins = 10
+
+// Pages whose angle is within this many degrees of zero are counted in AngleSummary.NearlyStraight
+const nearlyStraightDegrees = 0.5
+
+// AngleSummary is a statistical summary of a set of page angles, as produced by AngleStats
+type AngleSummary struct {
+	Count          int // Number of angles
+	Min            float64
+	Max            float64
+	Mean           float64
+	Median         float64
+	StdDev         float64 // Population standard deviation
+	MeanAbs        float64 // Mean of the absolute angles, which is the average amount of skew
+	MaxAbs         float64 // Largest absolute angle
+	NearlyStraight int     // Number of angles within ±0.5 degrees
+	// Counts of angles in equal width bins from Min to Max. Empty if there are no angles.
+	Histogram []AngleBin
+}
+
+// AngleBin is a bin of AngleSummary.Histogram
+type AngleBin struct {
+	Min   float64 // Inclusive
+	Max   float64 // Exclusive, except for the last bin, which includes Max
+	Count int
+}
+
+// Compute summary statistics of the angles returned by PageAngles
+func AngleStats(angles []float64) AngleSummary {
+	s := AngleSummary{Count: len(angles)}
+	if len(angles) == 0 {
+		return s
+	}
+	sorted := slices.Clone(angles)
+	slices.Sort(sorted)
+	s.Min = sorted[0]
+	s.Max = sorted[len(sorted)-1]
+	s.Median = median(sorted)
+
+	sum, sumAbs := 0.0, 0.0
+	for _, a := range angles {
+		sum += a
+		sumAbs += math.Abs(a)
+		s.MaxAbs = max(s.MaxAbs, math.Abs(a))
+		if math.Abs(a) <= nearlyStraightDegrees {
+			s.NearlyStraight++
+		}
+	}
+	n := float64(len(angles))
+	s.Mean = sum / n
+	s.MeanAbs = sumAbs / n
+	variance := 0.0
+	for _, a := range angles {
+		variance += (a - s.Mean) * (a - s.Mean)
+	}
+	s.StdDev = math.Sqrt(variance / n)
+
+	s.Histogram = angleHistogram(sorted, s.Min, s.Max)
+	return s
+}
+
+// Bin the angles into angleHistogramBins equal bins from lo to hi.
+// If all angles are equal, there is a single bin.
+func angleHistogram(angles []float64, lo, hi float64) []AngleBin {
+	if lo == hi {
+		return []AngleBin{{Min: lo, Max: hi, Count: len(angles)}}
+	}
+	width := (hi - lo) / angleHistogramBins
+	bins := make([]AngleBin, angleHistogramBins)
+	for i := range bins {
+		bins[i].Min = lo + float64(i)*width
+		bins[i].Max = lo + float64(i+1)*width
+	}
+	bins[len(bins)-1].Max = hi
+	for _, a := range angles {
+		i := min(int((a-lo)/width), len(bins)-1)
+		bins[i].Count++
+	}
+	return bins
+}
@@ -0,0 +1,67 @@
+package pdfstraighten
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAngleStats(t *testing.T) {
+	s := AngleStats(nil)
+	if s.Count != 0 || s.Histogram != nil {
+		t.Errorf("no angles: got %+v, expected an empty summary", s)
+	}
+
+	s = AngleStats([]float64{1, -1, 0.2, 2})
+	approx := func(name string, got, want float64) {
+		t.Helper()
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%v is %v, expected %v", name, got, want)
+		}
+	}
+	if s.Count != 4 {
+		t.Errorf("Count is %v, expected 4", s.Count)
+	}
+	approx("Min", s.Min, -1)
+	approx("Max", s.Max, 2)
+	approx("Median", s.Median, 0.6)
+	approx("Mean", s.Mean, 0.55)
+	approx("MeanAbs", s.MeanAbs, 1.05)
+	approx("MaxAbs", s.MaxAbs, 2)
+	approx("StdDev", s.StdDev, math.Sqrt(1.2075))
+	if s.NearlyStraight != 1 {
+		t.Errorf("NearlyStraight is %v, expected 1", s.NearlyStraight)
+	}
+	total := 0
+	for _, b := range s.Histogram {
+		total += b.Count
+	}
+	if len(s.Histogram) != angleHistogramBins || total != 4 {
+		t.Errorf("Histogram has %v bins holding %v angles, expected %v bins holding 4", len(s.Histogram), total, angleHistogramBins)
+	}
+}
+
+func TestAngleHistogram(t *testing.T) {
+	cases := []struct {
+		name   string
+		angles []float64 // Sorted
+		counts []int
+	}{
+		{"equal angles", []float64{1, 1, 1}, []int{3}},
+		{"bin edges", []float64{0, 0.99, 1, 5, 10}, []int{2, 1, 0, 0, 0, 1, 0, 0, 0, 1}},
+	}
+	for _, c := range cases {
+		bins := angleHistogram(c.angles, c.angles[0], c.angles[len(c.angles)-1])
+		if len(bins) != len(c.counts) {
+			t.Errorf("%v: got %v bins, expected %v", c.name, len(bins), len(c.counts))
+			continue
+		}
+		for i, b := range bins {
+			if b.Count != c.counts[i] {
+				t.Errorf("%v: bin %v [%v, %v] has %v angles, expected %v", c.name, i, b.Min, b.Max, b.Count, c.counts[i])
+			}
+		}
+		if bins[0].Min != c.angles[0] || bins[len(bins)-1].Max != c.angles[len(c.angles)-1] {
+			t.Errorf("%v: bins span [%v, %v], expected the range of the angles", c.name, bins[0].Min, bins[len(bins)-1].Max)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package pdfstraighten
+
+import (
+	"github.com/bmharper/cimg/v2"
+	"github.com/bmharper/textorient"
+)
+
+// The individual steps of straightening an image, for callers that want to interpose their own logic between
+// them (eg making the angles of neighbouring pages consistent). Each step uses the settings of the Document.
+// The images that a step returns may be handed to the next step, or encoded into a PDF with StraightenImage.
+
+// Measure the angle of img, searching between -maxAngle and +maxAngle degrees, with the same AngleDetector,
+// AutoWiden, and include90Degrees semantics as PageAngles. img is not modified.
+func (d *Document) DetectAngle(img *cimg.Image, maxAngle float64, include90Degrees bool) PageAngle {
+	angle, confidence := d.getImageAngle(0, img, maxAngle, include90Degrees)
+	return newPageAngle(angle, confidence)
+}
+
+// Rotate img to correct an angle as returned by DetectAngle, honouring RotateExpandThresholdDegrees, AlwaysExpand,
+// and RotateBackground. Returns img itself if angle is zero, and otherwise a new image.
+// Unlike Straighten, the whole angle is applied, regardless of DeskewOnly and MinCorrectAngleDegrees.
+func (d *Document) Rotate(img *cimg.Image, angle float64) *cimg.Image {
+	if angle == 0 {
+		return img
+	}
+	return d.rotateImage(img, -angle)
+}
+
+// Turn img upright with orient, honouring OrientMinConfidence and Check180.
+// Returns img itself if it is already upright, and otherwise a new image.
+func (d *Document) Orient(orient *textorient.Orient, img *cimg.Image) (*cimg.Image, error) {
+	var result PageResult
+	return d.orientImage(orient, img, &result)
+}
@@ -2,10 +2,17 @@ package pdfstraighten
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
 	"io"
 	"math"
 	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/bmharper/cimg/v2"
 	"github.com/bmharper/docangle"
@@ -13,26 +20,468 @@ import (
 	"github.com/gen2brain/go-fitz"
 	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
 	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
 )
 
-// Document represents a PDF document
+// Logger receives debug output. *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// PageAngle is the detected skew of a page
+type PageAngle struct {
+	Angle float64 // Degrees, as detected by docangle, so a page that was fed in sideways is near 90 or -90
+	// Angle split into quarter turns and the remaining skew, so that Angle = Skew + 90*QuarterTurns.
+	// A page with non-zero QuarterTurns was fed into the scanner sideways or upside down.
+	Skew         float64
+	QuarterTurns int
+	// The score of the best angle from docangle.GetAngleWhiteLines, normalized by the number of scan lines.
+	// Zero means that no angle had enough alternating text and white space to be trusted (eg a blank page).
+	Confidence float64
+}
+
+// PageResult describes what was done to a page during straightening
+type PageResult struct {
+	Page        int     // Zero-based page index
+	Angle       float64 // Skew correction in degrees (before orientation)
+	Orientation int     // Clockwise rotation in degrees applied by MakeUpright: 0, 90, 180, or 270
+	Flipped180  bool    // True if Check180 turned the page upside down
+	Modified    bool    // False if the original image of the page was passed through untouched
+	Blank       bool    // True if RemoveBlankPages dropped the page from the output PDF
+
+	// True if textorient wanted to rotate the page, but OrientMinConfidence, DisableOrient90, or DisableOrient180 left it alone
+	OrientationSuppressed bool
+	// True if the page angle turned the page by a quarter turn, and then MakeUpright turned it by another quarter turn,
+	// which means that angle detection and textorient disagree about which way the text runs. See OrientFirst.
+	OrientationConflict bool
+	// Fraction of the regions of the page that agreed with textorient's orientation of the whole page.
+	// Only measured when OrientMinConfidence is set, and the page is not already upright.
+	OrientationConfidence float64
+
+	// Variance of the projection profile (the darkness of each row) before and after deskewing, and the
+	// relative change (eg 0.5 for 50% sharper). A negative DeskewImprovement means that straightening made
+	// the page worse, which can happen on pages that are mostly graphics. Only measured if MeasureDeskewQuality is set.
+	ProfileVarianceBefore float64
+	ProfileVarianceAfter  float64
+	DeskewImprovement     float64
+}
+
+// A straightened page, ready to be written to the output PDF
+type straightenedPage struct {
+	page  int     // Zero-based page index in the source document
+	image []byte  // Encoded image. Nil if the page was skipped because of an error.
+	dpi   float64 // Resolution of image, which determines the physical size of its page (see pageImportConfig)
+	// False if image is the original image blob of the page, in which case the original page can be copied verbatim.
+	modified bool
+	blank    bool      // True if the page is to be left out of the output PDF
+	words    []OCRWord // Text recognized by Document.OCR, in the pixel coordinates of the image
+	width    int       // Pixel width of image
+	height   int       // Pixel height of image
+	result   PageResult
+	profile  PageProfile // Only measured if Document.Profile is set
+}
+
+// Returns an unprocessed straightenedPage for each of pages. If a page is skipped because of an error, it stays like this.
+func newStraightenedPages(pages []int) []straightenedPage {
+	straightPages := make([]straightenedPage, len(pages))
+	for i, page := range pages {
+		straightPages[i].page = page
+	}
+	return straightPages
+}
+
+// Returns the encoded images of pages
+func pageImages(pages []straightenedPage) [][]byte {
+	images := make([][]byte, len(pages))
+	for i, p := range pages {
+		images[i] = p.image
+	}
+	return images
+}
+
+// Document represents a PDF document.
+// The methods of a Document are safe for concurrent use, so one Document can serve several goroutines
+// without parsing the file again: access to the source, by both pdfcpu and go-fitz, is serialized by readerLock,
+// and the image cache is locked. The Settings must not be changed while methods are
+// running, and Close must only be called once all other calls have returned.
 type Document struct {
-	fz       *fitz.Document
-	reader   io.ReadSeeker
+	Settings
+	*documentSource
+
 	NumPages int
-	Verbose  bool // If true, print debug information
+
+	// Angles (in degrees, like those of PageAngles) that replace the detected or given angle of a page, keyed
+	// by zero-based page index. This is a declarative way to record human corrections to a wrong detection.
+	// An angle of NaN forces the page to be left alone: it is neither deskewed, nor turned upright.
+	// Consulted by Straighten, StraightenedImages, StraightenOnePass, and their variants.
+	AngleOverrides map[int]float64
+}
+
+// The source of a Document, and the state that is derived from it. This is separate from the Document, so
+// that StraightenWithOptions can run with its own Settings, on a Document that shares the source of the original.
+type documentSource struct {
+	fz         *fitz.Document
+	reader     io.ReadSeeker
+	readerLock sync.Mutex     // Guards reader, which pdfcpu seeks around in, and fz, which Close frees
+	abandoned  sync.WaitGroup // Page work run by PerPageTimeout, which may outlive its call, so Close waits for it
+	tiff       *tiffSource    // If not nil, the document is a TIFF file, and fz and reader are nil
+	imageCache imageCache
+	pageInfo   []pageInfo // Cached physical page properties, guarded by readerLock
 }
 
+// Settings holds the settings of a Document, which are promoted to fields of the Document, eg d.OutputQuality.
+// It is a plain value, so one Settings can be reused for many documents by assigning it to the Settings field
+// of each. Reference settings, such as Logger, OCR, AngleDetector, and the callbacks, are then shared between
+// the documents, as are the targets of pointer settings, such as Metadata and PDFConfiguration, which must
+// therefore not be modified while any of the documents are in use.
+type Settings struct {
+	Verbose     bool   // If true, print debug information
+	Logger      Logger // Destination of debug information. If nil, it is printed to stdout.
+	Concurrency int    // Number of pages to process in parallel. Values less than 2 mean sequential processing.
+
+	OutputQuality  int           // JPEG quality (1..100) of straightened pages. Default 95.
+	OutputSampling cimg.Sampling // JPEG chroma sampling of straightened pages. Default 4:4:4.
+	OutputFormat   OutputFormat  // Encoding of straightened pages. Default FormatJPEG.
+	OutputFlags    cimg.Flags    // turbojpeg flags of JPEG output, eg cimg.FlagProgressive for incremental display. Default 0.
+
+	// If true, straightened pages whose source is a JPEG are encoded with the chroma sampling of the source,
+	// instead of OutputSampling. A 4:2:0 source then stays 4:2:0, rather than growing by being stored at 4:4:4,
+	// and a 4:4:4 source doesn't lose chroma detail to a coarser OutputSampling.
+	// Rotation by an arbitrary angle can't be done on the DCT coefficients, so the page is still re-encoded.
+	MatchSourceSampling bool
+
+	// If true, straightened pages are converted to grayscale before they are encoded, in any OutputFormat.
+	// This makes black text on white paper much smaller. Color pages that need no straightening are converted too.
+	OutputGrayscale bool
+
+	// If true, each page is converted to grayscale before it is encoded if its pixels are effectively gray, and
+	// otherwise kept in color, which suits an archive of mostly black and white pages with the odd color page.
+	// A page is in color if more than one in a thousand of its pixels have a chroma (the difference between their
+	// largest and smallest channel) above AutoGrayscaleChroma, which is 0..255, and by default 24, so that JPEG
+	// noise doesn't count as color, but a small colored stamp does. Gray pages that need no straightening are converted too.
+	AutoGrayscale       bool
+	AutoGrayscaleChroma int
+
+	// Encoder for FormatWebP, such as a binding to libwebp. quality is OutputQuality.
+	// Neither cimg nor golang.org/x/image can encode WebP, so this must be supplied by the caller.
+	WebPEncoder func(img image.Image, quality int) ([]byte, error)
+
+	// If true (the default), output pages have the same physical size as the source pages.
+	// If false, output pages are sized at one point per pixel.
+	PreservePageSize bool
+
+	// If true, each straightened page image is padded with RotateBackground (or white, if that is nil) until it
+	// has the aspect ratio of its source page. Some scanners place an image that doesn't fill the page, leaving
+	// a white border that is part of the page geometry rather than the pixels, and without padding, the output
+	// page shrinks to the image and loses that border. The image is centered, because its original placement
+	// on the page is not known. Pages that need no straightening are padded (and so re-encoded) too.
+	PadToPageAspect bool
+
+	// Placement of page images in an output PDF that we build from scratch. The default, types.Full, makes each
+	// page the size of its image (see PreservePageSize). Any other anchor puts the image on a page of
+	// OutputPageSize (or of the size that PreservePageSize would choose, if OutputPageSize is nil),
+	// scaled by OutputPageScale to fit, and anchored at that position.
+	OutputPagePosition types.Anchor
+	OutputPageScale    float64    // Fraction (0..1] of the page that the image may occupy. Default 1.
+	OutputPageSize     *types.Dim // Page dimensions in points, such as types.PaperSize["A4"]. Ignored with types.Full.
+	// If true, OutputPageSize is turned to landscape for landscape images, and to portrait for portrait images
+	OutputPageMatchOrientation bool
+
+	// If true, pages without a usable image (eg vector content, or an image codec that we can't decode) are
+	// rendered whole with go-fitz, and the rendering is straightened instead. Otherwise, such pages fail.
+	// IsScanned doesn't disqualify a page for having no image, because its rendering is what we straighten.
+	RenderFallback bool
+
+	// Resolution at which pages are rendered by RenderFallback, and when an OnPageError skips a page.
+	// Output pages keep their physical size, whatever the resolution. Default 200.
+	// Renderings of more than 100 megapixels fail with ErrRenderTooLarge instead of exhausting memory.
+	RenderDPI int
+
+	// If true, pages with more than one image (eg a scan split into strips, or a stamp overlay) are
+	// composited into a single image before processing, instead of being rejected.
+	CompositeImages bool
+
+	// If true, pages whose images are all the same width are treated as a single scan that was
+	// split into horizontal strips, as some high-speed production scanners do. The strips are
+	// stacked vertically, at their native resolution, into one image before processing.
+	// Pages with images of differing widths fall through to CompositeImages.
+	ConcatenateImageStrips bool
+
+	// If not nil, called at the start of processing each page, with the zero-based page index,
+	// and the number of pages. When processing a page range, these are the position within the range, and its length.
+	// When Concurrency is greater than 1, this is called from multiple goroutines simultaneously,
+	// and pages may be reported out of order, so the function must be safe for concurrent use.
+	ProgressFunc func(page, total int)
+
+	// If not nil, every straightened page is passed through OCR, and the recognized words are placed over
+	// the page image as invisible text, making the output PDF searchable. Blank pages are not recognized.
+	OCR OCREngine
+
+	// If not nil, called when processing a page fails. If it returns nil, the page is skipped, and processing
+	// continues with the next page. Otherwise, the returned error aborts the whole operation.
+	// A skipped page has an angle of zero, a nil image from StraightenedImages, and in an output PDF,
+	// it is a rendering of the original page (or the original page itself, if PassThroughUnchanged is set).
+	// Has the same concurrency contract as ProgressFunc. Test err with errors.Is (eg ErrNoImageOnPage) to decide what to do.
+	OnPageError func(page int, err error) error
+
+	// If greater than zero, a page whose processing takes longer than this fails with ErrPageTimeout, so that a
+	// pathological image can't stall a whole batch. Combine it with OnPageError to skip such pages.
+	// The work on an abandoned page can't be interrupted, so it carries on in the background until it finishes (and Close waits for it),
+	// and may still call PageResultFunc and DebugSink.
+	PerPageTimeout time.Duration
+
+	// If not nil, called after each page is straightened, with the same concurrency contract as ProgressFunc.
+	PageResultFunc func(result PageResult)
+
+	// If not nil, called with the intermediate images of each page, for diagnosing pages that come out crooked.
+	// stage is one of the DebugStage constants. The images may be shared with the pipeline and the image cache,
+	// so they must not be modified, or retained after returning. Standalone images (eg StraightenImageBytes) are page 0.
+	// Has the same concurrency contract as ProgressFunc.
+	DebugSink func(page int, stage string, img *cimg.Image)
+
+	// If greater than zero, a page is only rotated by MakeUpright if at least this fraction (0..1) of the
+	// regions of the page agree with the orientation of the whole page. textorient doesn't report its
+	// confidence, and can be unsure about foreign language text, so this is how we avoid rotating correctly
+	// oriented pages. Pages that are too small to split into regions are never rotated when this is set.
+	OrientMinConfidence float64
+
+	// If true, the page is turned upright by MakeUpright before it is deskewed, instead of after. The quarter turns
+	// of the page angle are then ignored, because textorient alone decides which way is up, and only the skew is
+	// corrected. This avoids a page being turned sideways by its angle and then back again by MakeUpright
+	// (see PageResult.OrientationConflict), at the cost of running textorient on the skewed page.
+	OrientFirst bool
+
+	// If true, run a second orientation check after MakeUpright, and turn the page upside down if
+	// the text orientation network strongly believes that it is still upside down.
+	Check180 bool
+
+	// textorient was trained on Latin text, and can misjudge the orientation of other scripts, such as Arabic or CJK.
+	// If DisableOrient90 is true, MakeUpright never turns a page by 90 or 270 degrees, which leaves the direction of
+	// the text lines to angle detection (use include90Degrees, so that sideways pages are turned by their angle).
+	// If DisableOrient180 is true, MakeUpright never turns a page upside down, and Check180 is ignored.
+	// A rotation that is disabled is reported as PageResult.OrientationSuppressed.
+	DisableOrient90  bool
+	DisableOrient180 bool
+
+	// Measures the angle of each page. If nil, a WhiteLinesDetector with DetectMaxDimension and DetectBinarize is used.
+	// Must be safe for concurrent use when Concurrency is greater than 1.
+	AngleDetector AngleDetector
+
+	// If not nil, a second AngleDetector (such as EdgeDetector) that is consulted when AngleDetector's confidence
+	// in the angle of a page is no more than FallbackMinConfidence, which is zero by default, meaning that the
+	// fallback is only consulted for pages on which no angle could be trusted. Whichever estimate is more confident
+	// is used, so the two detectors' confidences should be on a comparable scale (both WhiteLinesDetector and
+	// EdgeDetector report a fraction between 0 and 1). Must be safe for concurrent use when Concurrency is greater than 1.
+	FallbackDetector      AngleDetector
+	FallbackMinConfidence float64
+
+	// Pages are scaled down so that neither side exceeds this many pixels before measuring their angle,
+	// because extra resolution makes angle detection slower, but no more accurate. Straightening is always
+	// done at full resolution. Zero disables scaling. Default 1000, which is what docangle itself uses.
+	// This only applies to the default AngleDetector.
+	DetectMaxDimension int
+
+	// If true, the default AngleDetector binarizes pages with an adaptive threshold before measuring their angle,
+	// which gives a more stable angle on faint scans. The output is unaffected.
+	// DetectBinarizeWindow is the size of the threshold's neighbourhood (see WhiteLinesDetector.BinarizeWindow).
+	DetectBinarize       bool
+	DetectBinarizeWindow int
+
+	// If true, and the detected angle of a page is at the limit of the maxAngle search range, then we keep
+	// doubling the range, up to AutoWidenMaxDegrees, until the angle falls inside it.
+	// This catches pages that were fed into the scanner more crooked than usual.
+	AutoWiden           bool
+	AutoWidenMaxDegrees float64 // Upper limit of the widened search range, in degrees. Default 10. Never more than 45.
+
+	// If true, the angles returned by PageAngles (and its variants) are smoothed across pages, because pages that
+	// went through the same document feeder share nearly the same skew, and the independent estimate of each page
+	// wobbles around it. A page whose skew differs from the median skew of its neighbours by more than
+	// SmoothAnglesOutlierDegrees (default 0.15) is snapped to that median. The neighbours are the SmoothAnglesWindow
+	// pages centered on the page, or the whole document if SmoothAnglesWindow is less than 2. Pages with zero
+	// confidence, such as skipped pages, are left alone. StraightenOnePass measures each page in isolation, so it is not smoothed.
+	SmoothAngles               bool
+	SmoothAnglesWindow         int
+	SmoothAnglesOutlierDegrees float64
+
+	// Skew angles smaller than this many degrees are not corrected, so that a page which is already straight
+	// (give or take the precision of angle detection) keeps its original image, instead of being re-encoded.
+	// This makes it safe to straighten the same document more than once. Default 0.
+	MinCorrectAngleDegrees float64
+
+	// If true, pages are only ever turned by multiples of 90 degrees, for documents that are never skewed, but
+	// are sometimes fed into the scanner sideways or upside down. Detected and given angles are snapped to the
+	// nearest multiple of 90 degrees (so include90Degrees must be set for sideways pages to be turned by their
+	// angle), and pages are turned by moving pixels, never by resampling them. A page that needs no turn keeps its
+	// original image. A page that is turned is still re-encoded, because cimg can't turn a JPEG losslessly in the
+	// DCT domain, so use FormatPNG if every pixel must survive.
+	OrthogonalOnly bool
+
+	// If true, measure how much deskewing improved each page, and report it in PageResult
+	MeasureDeskewQuality bool
+
+	// If true, time each stage of processing each page, and record the size of each decoded page image,
+	// for StraightenWithProfile and StraightenOnePassWithProfile. When false, nothing is measured.
+	Profile bool
+
+	// If true, only correct the skew of each page, and leave its orientation alone. MakeUpright is not run,
+	// and any multiple of 90 degrees in a page angle is ignored. Passing a nil Orient has the same effect.
+	DeskewOnly bool
+
+	// When rotating by less than this many degrees (away from 0 or 90), the rotated image is clipped to the
+	// original size, because there's usually padding implicitly added by the rotated scan.
+	// Larger rotations expand the canvas so that no content is lost. Default 5.
+	// Pages are rotated with bilinear interpolation, which is the only filter that cimg implements.
+	RotateExpandThresholdDegrees float64
+	AlwaysExpand                 bool // If true, always expand the canvas, regardless of RotateExpandThresholdDegrees
+
+	// If true, the black borders that a scanner leaves around a page (where the lid didn't cover the glass)
+	// are cropped away after straightening. AutoCropPadding is the number of pixels kept around the content.
+	AutoCrop        bool
+	AutoCropPadding int
+
+	// If greater than zero, straightened pages are sharpened by an unsharp mask of this strength before they are
+	// encoded, to counter the slight blur of rotating by a fraction of a degree, which helps OCR. 0.5 is light, and
+	// 1 is strong. If Denoise is true, pages are first cleaned of isolated specks by a 3x3 median filter, which suits
+	// noisy gray scans. Both apply to every page, so pages that need no straightening are re-encoded too. Default off.
+	PostSharpen float64
+	Denoise     bool
+
+	// Color of the regions that are uncovered by rotating a page (eg the corners of an expanded canvas).
+	// Default white. If nil, the edge pixels of the page are smeared outwards.
+	RotateBackground color.Color
+
+	// IsScanned requires the image on every page to have at least this many pixels. Default 800*600.
+	// A document with one image per page is not necessarily a scan: it could be a born-digital document with
+	// a logo on every page. go-fitz doesn't always manage to extract the text of such documents, so this
+	// threshold is our fallback for telling a little logo apart from a scanned page.
+	MinScanPixels int
+
+	// If greater than zero, IsScanned accepts a document in which at least this fraction (0..1] of the pages are
+	// scanned, instead of requiring every page to be, so that eg a scan with a computer generated cover page qualifies.
+	// Combine it with StraightenScannedOnly (and PassThroughUnchanged) to process only the scanned pages of such a document.
+	MinScannedFraction float64
+
+	// If true, pages that PageScanReasons doesn't classify as scanned are left alone by PageAngles, Straighten,
+	// StraightenedImages, StraightenOnePass, and their variants, as if an OnPageError had skipped them.
+	// Their angle is zero, StraightenedImages returns a nil image for them, and in an output PDF they are the
+	// original page if PassThroughUnchanged is set, and otherwise a rendering of it.
+	StraightenScannedOnly bool
+
+	// If true (the default), pages are rotated by their PDF /Rotate attribute before processing,
+	// so that we work on the page the way a viewer displays it, rather than on the raw image pixels.
+	ApplyPageRotation bool
+
+	// If true (the default), a JPEG page image with an EXIF orientation tag (eg a phone photo of a document)
+	// is turned the way the camera intended before processing, and before /Rotate is applied.
+	// PDF viewers ignore EXIF, so such a page may look sideways in the source PDF, but not in the output.
+	// Without this, a page with a 90 degree EXIF orientation is measured sideways, which gives a wrong angle
+	// unless include90Degrees is set. MakeUpright would then turn the page upright, but only if it is given an Orient.
+	ApplyExifOrientation bool
+
+	// If true (the default), the Title, Author, Subject, Keywords, Creator, and CreationDate of the source
+	// document are copied to the output.
+	PreserveMetadata bool
+
+	// If true (the default), each output page keeps the page label of its source page (eg "iv" for front matter),
+	// so that the numbering shown by PDF viewers doesn't change. See PageLabels.
+	PreservePageLabels bool
+
+	// If true, producing a PDF of the whole document copies the source document, and only replaces the pages whose image
+	// was modified, instead of building a new document from page images. Untouched pages keep their original content,
+	// and everything else in the document (outlines, annotations, form fields) survives.
+	// This has no effect when producing a PDF from a subset of pages.
+	PassThroughUnchanged bool
+
+	// By default, producing a PDF of the whole document returns the source document byte for byte when no page
+	// needs to change: no page was straightened, turned upright, cropped, re-encoded, given a text layer, or dropped,
+	// the metadata is not being changed, and no setting changes the page layout (PreservePageSize, OutputPagePosition,
+	// OutputPageScale, and OutputPageSize are at their defaults). This keeps already straight documents lossless,
+	// including any vector and text content. If true, a new document is always built instead.
+	ForceRebuild bool
+
+	// If true, blank pages (eg the empty back sides of a duplex scan) are left out of the output PDF.
+	// StraightenedImages still returns an image for every page, so that it stays aligned with the page angles.
+	RemoveBlankPages bool
+
+	// A page is blank if at least this fraction of its pixels are white, after thresholding to black and white.
+	// The margins of the page are ignored, because they often hold scanner shadows and punch holes. Default 0.997.
+	BlankPageThreshold float64
+
+	// If not nil, the non-empty fields of Metadata override the metadata of the output document.
+	Metadata *Metadata
+
+	// If greater than zero, page images with more than this many pixels are not decoded, because a single
+	// huge scan (eg a poster at 600 DPI) can exhaust memory. Such pages fail with ErrImageTooLarge, unless
+	// DownscaleOversizedImages is set, in which case the page is rendered by go-fitz at the highest resolution
+	// that fits within the limit. The limit also applies to the renderings of RenderFallback and CompositeImages.
+	MaxDecodedPixels         int
+	DownscaleOversizedImages bool
+
+	// Maximum number of decoded page images that are kept in memory, so that a second pass over the
+	// document (eg PageAngles followed by Straighten) doesn't extract and decode every page again.
+	// A full-page scan at 300 DPI occupies about 25 MB. Zero (the default) disables the cache.
+	ImageCacheSize int
+
+	// pdfcpu configuration for reading the source document and writing the output, eg to choose between
+	// model.ValidationRelaxed (pdfcpu's default) and model.ValidationStrict. If nil, model.NewDefaultConfiguration
+	// is used, which honours the user's pdfcpu config.yml. It is copied for every use, because pdfcpu modifies it.
+	// It must be set before the first page is read, because the images in the image cache are not keyed on it.
+	// Call ClearImageCache if it must change after that.
+	PDFConfiguration *model.Configuration
+}
+
+// Takes ownership of fz and reader, so if this fails, they are closed (unless reader hides its Close method).
+// Constructors must not touch them after calling this.
 func newDocument(fz *fitz.Document, reader io.ReadSeeker) (*Document, error) {
-	doc := &Document{
-		fz:       fz,
-		reader:   reader,
-		NumPages: fz.NumPage(),
-	}
+	doc := newEmptyDocument()
+	doc.fz = fz
+	doc.reader = reader
+	doc.NumPages = fz.NumPage()
 	return doc, nil
 }
 
+// Returns a Document with default settings, but no content
+func newEmptyDocument() *Document {
+	return &Document{
+		documentSource: &documentSource{},
+		Settings:       DefaultSettings(),
+	}
+}
+
+// Returns the settings of a new Document. Start from these when building a Settings to reuse for many documents,
+// because the zero Settings is not the default (eg its OutputQuality is 0, and ApplyPageRotation is false).
+func DefaultSettings() Settings {
+	return Settings{
+		OutputQuality:  95,
+		OutputSampling: cimg.Sampling444,
+
+		PreservePageSize:   true,
+		OutputPagePosition: types.Full,
+		OutputPageScale:    1,
+
+		RotateExpandThresholdDegrees: 5,
+		RotateBackground:             color.White,
+
+		AutoGrayscaleChroma: 24,
+
+		MinScanPixels: 800 * 600,
+		RenderDPI:     200,
+
+		BlankPageThreshold: 0.997,
+
+		AutoWidenMaxDegrees: 10,
+		DetectMaxDimension:  1000,
+
+		SmoothAnglesOutlierDegrees: defaultSmoothOutlierDegrees,
+
+		ApplyPageRotation:    true,
+		ApplyExifOrientation: true,
+		PreserveMetadata:     true,
+		PreservePageLabels:   true,
+	}
+}
+
 // Load a PDF from a file
 func NewDocumentFromFile(filename string) (*Document, error) {
 	fz, err := fitz.New(filename)
@@ -40,6 +489,10 @@ func NewDocumentFromFile(filename string) (*Document, error) {
 		return nil, err
 	}
 	file, err := os.Open(filename)
+	if err != nil {
+		fz.Close()
+		return nil, err
+	}
 	return newDocument(fz, file)
 }
 
@@ -53,7 +506,36 @@ func NewDocumentFromMemory(doc []byte) (*Document, error) {
 	return newDocument(fz, bytes.NewReader(doc))
 }
 
+// Load a PDF from an io.ReadSeeker.
+// go-fitz can only open a file by name, or from memory. If r is an *os.File, go-fitz opens the file by
+// name, and neither library reads the whole file into memory. For any other reader, the entire
+// document is read into memory, so this is no cheaper than NewDocumentFromMemory.
+// The caller retains ownership of r, which must remain open, and unmodified, until the Document is closed.
+func NewDocumentFromReadSeeker(r io.ReadSeeker) (*Document, error) {
+	if file, ok := r.(*os.File); ok {
+		fz, err := fitz.New(file.Name())
+		if err != nil {
+			return nil, err
+		}
+		// Hide the file's Close method from Document.Close, because the caller owns file.
+		// newDocument still closes fz if it fails.
+		return newDocument(fz, struct{ io.ReadSeeker }{file})
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	doc, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewDocumentFromMemory(doc)
+}
+
 func (d *Document) Close() {
+	// Work that timed out still uses the source, so wait for it to finish before freeing anything
+	d.abandoned.Wait()
+	d.readerLock.Lock()
+	defer d.readerLock.Unlock()
 	if d.reader != nil {
 		if closer, ok := d.reader.(io.Closer); ok {
 			closer.Close()
@@ -64,159 +546,691 @@ func (d *Document) Close() {
 		d.fz.Close()
 		d.fz = nil
 	}
+	d.imageCache.clear()
 }
 
-// Returns true if this PDF is a scanned document
-func (d *Document) IsScanned() (bool, error) {
-	// pdfcpu is not able to extract the text from the document, which is why we use
-	// go-fitz for this. Checking that there is 1 image per page is not sufficient,
-	// because what if a document has exactly one logo image per page, and the logo
-	// happens to be quite high resolution, mimicking a scanned page.
-	// However, it is a necessary condition that there be precisely one image per page.
+// Returns an array of page angles (in degrees) for the document.
+func (d *Document) PageAngles(maxAngle float64, include90Degrees bool) ([]float64, error) {
+	return d.PageAnglesContext(context.Background(), maxAngle, include90Degrees)
+}
 
-	// Extract all images and their resolutions
-	allPages := []string{}
-	for i := range d.fz.NumPage() {
-		allPages = append(allPages, fmt.Sprintf("%d", i+1))
-	}
-	allImages, err := pdfapi.Images(d.reader, allPages, nil)
+// PageAnglesContext is PageAngles, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) PageAnglesContext(ctx context.Context, maxAngle float64, include90Degrees bool) ([]float64, error) {
+	pageAngles, err := d.PageAnglesWithConfidenceContext(ctx, maxAngle, include90Degrees)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	for i := range allImages {
-		imagesOnPage := allImages[i]
-		if len(imagesOnPage) != 1 {
-			return false, nil
-		}
-		for _, img := range imagesOnPage {
-			// go-fitz sometimes fails to extract text, so we need this criteria as a fallback for documents
-			// with one little logo image on every page, and some text.
-			pixels := img.Width * img.Height
-			if pixels < 800*600 {
-				return false, nil
-			}
-		}
+	angles := make([]float64, len(pageAngles))
+	for i, pa := range pageAngles {
+		angles[i] = pa.Angle
 	}
+	return angles, nil
+}
 
-	for i := range d.fz.NumPage() {
-		txt, err := d.fz.Text(i)
-		if err != nil {
-			return false, err
-		}
-		if txt != "" {
-			return false, nil
+// Returns a copy of angles (as returned by PageAngles), with angles near 90 degrees (between 80 and 100)
+// reduced by 90 degrees, unless allow90Degrees is true. Instead of rotating 90 degrees, and thereby requiring
+// landscape pages, such pages are just rotated enough to straighten them. The text orientation
+// step of Straighten will then turn them upright.
+func NormalizeAngles(angles []float64, allow90Degrees bool) []float64 {
+	normalized := slices.Clone(angles)
+	if allow90Degrees {
+		return normalized
+	}
+	for i, a := range normalized {
+		if a > 80 && a < 100 {
+			normalized[i] = a - 90
 		}
 	}
-	return true, nil
+	return normalized
 }
 
-// Returns an array of page angles (in degrees) for the document.
-func (d *Document) PageAngles(maxAngle float64, include90Degrees bool) ([]float64, error) {
-	angles := []float64{}
+// Returns the page angles (in degrees) for the document, along with the confidence of each angle.
+func (d *Document) PageAnglesWithConfidence(maxAngle float64, include90Degrees bool) ([]PageAngle, error) {
+	return d.PageAnglesWithConfidenceContext(context.Background(), maxAngle, include90Degrees)
+}
+
+// PageAnglesWithConfidenceContext is PageAnglesWithConfidence, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) PageAnglesWithConfidenceContext(ctx context.Context, maxAngle float64, include90Degrees bool) ([]PageAngle, error) {
+	return d.pageAngles(ctx, d.allPages(), maxAngle, include90Degrees)
+}
+
+func newPageAngle(angle, confidence float64) PageAngle {
+	skew := skewOnly(angle)
+	return PageAngle{
+		Angle:        angle,
+		Skew:         skew,
+		QuarterTurns: int(math.Round((angle - skew) / 90)),
+		Confidence:   confidence,
+	}
+}
 
-	for page := 0; page < d.NumPages; page++ {
-		raw, img, err := d.getImageOnPage(page)
+// Returns the angles of the given pages
+func (d *Document) pageAngles(ctx context.Context, pages []int, maxAngle float64, include90Degrees bool) ([]PageAngle, error) {
+	angles := make([]PageAngle, len(pages))
+	unscanned, err := d.unscannedPages()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.forEachPage(ctx, pages, func(i, page int) error {
+		if unscanned != nil && unscanned[page] {
+			return nil
+		}
+		pa, err := withPageTimeout(d, page, func() (PageAngle, error) {
+			raw, img, err := d.getCachedImageOnPage(page)
+			if err != nil {
+				return PageAngle{}, err
+			}
+			angle, confidence := d.getImageAngle(page, img, maxAngle, include90Degrees)
+			d.verbose("page %v: %8v %.1f (confidence %.3f)\n", page+1, len(raw), angle, confidence)
+			return newPageAngle(angle, confidence), nil
+		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		angle := d.getImageAngle(img, maxAngle, include90Degrees)
-		angles = append(angles, angle)
-		d.verbose("page %v: %8v %.1f\n", page+1, len(raw), angle)
+		angles[i] = pa
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if d.SmoothAngles {
+		angles = smoothAngles(angles, d.SmoothAnglesWindow, d.SmoothAnglesOutlierDegrees)
 	}
 	return angles, nil
 }
 
 // Compute angles and produce straightened PDF in a single pass.
 // Returns a new version of the PDF, with rotated pages straightened.
-// We only scan between -maxAngle and +maxAngle degrees.
-func (d *Document) StraightenOnePass(orient *textorient.Orient, maxAngle float64) ([]byte, error) {
-	straightImages := [][]byte{}
+// We only scan between -maxAngle and +maxAngle degrees, and include90Degrees has the same meaning as in PageAngles.
+func (d *Document) StraightenOnePass(orient *textorient.Orient, maxAngle float64, include90Degrees bool) ([]byte, error) {
+	return d.StraightenOnePassContext(context.Background(), orient, maxAngle, include90Degrees)
+}
 
-	for page := 0; page < d.NumPages; page++ {
-		raw, img, err := d.getImageOnPage(page)
-		if err != nil {
-			return nil, err
+// StraightenOnePassContext is StraightenOnePass, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenOnePassContext(ctx context.Context, orient *textorient.Orient, maxAngle float64, include90Degrees bool) ([]byte, error) {
+	pdf, _, err := d.StraightenOnePassWithAnglesContext(ctx, orient, maxAngle, include90Degrees)
+	return pdf, err
+}
+
+// Same as StraightenOnePass, but also returns the angle that was detected on each page, as PageAnglesWithConfidence would.
+// Pages that were skipped, or whose angle is in AngleOverrides, have a zero PageAngle, because their angle was not detected.
+func (d *Document) StraightenOnePassWithAngles(orient *textorient.Orient, maxAngle float64, include90Degrees bool) ([]byte, []PageAngle, error) {
+	return d.StraightenOnePassWithAnglesContext(context.Background(), orient, maxAngle, include90Degrees)
+}
+
+// StraightenOnePassWithAnglesContext is StraightenOnePassWithAngles, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenOnePassWithAnglesContext(ctx context.Context, orient *textorient.Orient, maxAngle float64, include90Degrees bool) ([]byte, []PageAngle, error) {
+	straightPages, angles, err := d.straightenOnePass(ctx, orient, maxAngle, include90Degrees)
+	if err != nil {
+		return nil, nil, err
+	}
+	pdf, err := d.buildPDF(straightPages)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pdf, angles, nil
+}
+
+// Detect the angle of every page, and straighten it, in a single pass over the document
+func (d *Document) straightenOnePass(ctx context.Context, orient *textorient.Orient, maxAngle float64, include90Degrees bool) ([]straightenedPage, []PageAngle, error) {
+	straightPages := newStraightenedPages(d.allPages())
+	angles := make([]PageAngle, d.NumPages)
+	unscanned, err := d.unscannedPages()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = d.forEachPage(ctx, d.allPages(), func(i, page int) error {
+		if unscanned != nil && unscanned[page] {
+			return nil
 		}
-		angle := d.getImageAngle(img, maxAngle, false)
-		fixed, err := d.straightenImage(orient, raw, img, angle)
+		type onePassResult struct {
+			page     straightenedPage
+			detected PageAngle
+		}
+		r, err := withPageTimeout(d, page, func() (onePassResult, error) {
+			start := d.profileStart()
+			raw, img, err := d.getCachedImageOnPage(page)
+			if err != nil {
+				return onePassResult{}, err
+			}
+			decode := d.profileSince(start)
+			// An overridden page doesn't need its angle detected
+			var detected PageAngle
+			start = d.profileStart()
+			if _, ok := d.AngleOverrides[page]; !ok {
+				detected = newPageAngle(d.getImageAngle(page, img, maxAngle, include90Degrees))
+			}
+			detect := d.profileSince(start)
+			sp, err := d.straightenPage(orient, page, raw, img, detected.Angle)
+			d.profileDecode(&sp, img, decode)
+			sp.profile.Detect = detect
+			return onePassResult{sp, detected}, err
+		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		straightImages = append(straightImages, fixed)
+		straightPages[i] = r.page
+		angles[i] = r.detected
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
-
-	return d.buildNewPDF(straightImages)
+	return straightPages, angles, nil
 }
 
 // Given the list of page angles obtained by PageAngles(), straighten each image and return the list of compressed images
+// pageAngles must have an angle for every page, or the error is ErrAngleCountMismatch.
 func (d *Document) StraightenedImages(orient *textorient.Orient, pageAngles []float64) ([][]byte, error) {
-	straightImages := [][]byte{}
+	return d.StraightenedImagesContext(context.Background(), orient, pageAngles)
+}
 
-	for page := 0; page < d.NumPages; page++ {
-		raw, img, err := d.getImageOnPage(page)
-		if err != nil {
-			return nil, err
+// StraightenedImagesContext is StraightenedImages, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenedImagesContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64) ([][]byte, error) {
+	straightPages, err := d.straightenedImages(ctx, orient, d.allPages(), pageAngles)
+	if err != nil {
+		return nil, err
+	}
+	return pageImages(straightPages), nil
+}
+
+// Returns the straightened images of the given pages.
+// pageAngles and the result are aligned with pages.
+func (d *Document) straightenedImages(ctx context.Context, orient *textorient.Orient, pages []int, pageAngles []float64) ([]straightenedPage, error) {
+	if err := validateAngleCount(pages, pageAngles); err != nil {
+		return nil, err
+	}
+	straightPages := newStraightenedPages(pages)
+	unscanned, err := d.unscannedPages()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.forEachPage(ctx, pages, func(i, page int) error {
+		if unscanned != nil && unscanned[page] {
+			return nil
 		}
-		angle := pageAngles[page]
-		fixed, err := d.straightenImage(orient, raw, img, angle)
+		sp, err := withPageTimeout(d, page, func() (straightenedPage, error) {
+			start := d.profileStart()
+			raw, img, err := d.getCachedImageOnPage(page)
+			if err != nil {
+				return straightenedPage{}, err
+			}
+			decode := d.profileSince(start)
+			sp, err := d.straightenPage(orient, page, raw, img, pageAngles[i])
+			d.profileDecode(&sp, img, decode)
+			return sp, err
+		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		straightImages = append(straightImages, fixed)
+		straightPages[i] = sp
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return straightImages, nil
+	return straightPages, nil
 }
 
 // Given the list of page angles obtained by PageAngles(), produce a straightened version of the document
+// pageAngles must have an angle for every page, or the error is ErrAngleCountMismatch.
 func (d *Document) Straighten(orient *textorient.Orient, pageAngles []float64) ([]byte, error) {
-	straightImages, err := d.StraightenedImages(orient, pageAngles)
+	return d.StraightenContext(context.Background(), orient, pageAngles)
+}
+
+// StraightenContext is Straighten, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64) ([]byte, error) {
+	straightPages, err := d.straightenedImages(ctx, orient, d.allPages(), pageAngles)
+	if err != nil {
+		return nil, err
+	}
+	return d.buildPDF(straightPages)
+}
+
+// Same as Straighten, but also returns the source page index of each output page.
+// Output page i came from source page pageMap[i]. Pages dropped by RemoveBlankPages don't appear.
+func (d *Document) StraightenWithPageMap(orient *textorient.Orient, pageAngles []float64) ([]byte, []int, error) {
+	return d.StraightenWithPageMapContext(context.Background(), orient, pageAngles)
+}
+
+// StraightenWithPageMapContext is StraightenWithPageMap, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenWithPageMapContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64) ([]byte, []int, error) {
+	straightPages, err := d.straightenedImages(ctx, orient, d.allPages(), pageAngles)
+	if err != nil {
+		return nil, nil, err
+	}
+	pdf, err := d.buildPDF(straightPages)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pdf, outputPageMap(straightPages), nil
+}
+
+// Same as Straighten, but also returns the size of each output page, in points, as a viewer would display it
+// (ie after applying the page's /Rotate). This lets a caller check that no page was unexpectedly turned sideways,
+// without having to parse the output. Pages dropped by RemoveBlankPages don't appear.
+func (d *Document) StraightenWithPageSizes(orient *textorient.Orient, pageAngles []float64) ([]byte, []types.Dim, error) {
+	return d.StraightenWithPageSizesContext(context.Background(), orient, pageAngles)
+}
+
+// StraightenWithPageSizesContext is StraightenWithPageSizes, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenWithPageSizesContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64) ([]byte, []types.Dim, error) {
+	straightPages, err := d.straightenedImages(ctx, orient, d.allPages(), pageAngles)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.buildPDFWithSizes(straightPages)
+}
+
+// Given the list of page angles obtained by PageAngles(), produce a separate single-page PDF for each page.
+// Pages dropped by RemoveBlankPages don't appear.
+func (d *Document) StraightenToPages(orient *textorient.Orient, pageAngles []float64) ([][]byte, error) {
+	return d.StraightenToPagesContext(context.Background(), orient, pageAngles)
+}
+
+// StraightenToPagesContext is StraightenToPages, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenToPagesContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64) ([][]byte, error) {
+	if err := d.validatePDFOutput(); err != nil {
+		return nil, err
+	}
+	straightPages, err := d.straightenedImages(ctx, orient, d.allPages(), pageAngles)
 	if err != nil {
 		return nil, err
 	}
-	return d.buildNewPDF(straightImages)
+	if d.RemoveBlankPages && countBlank(straightPages) == len(straightPages) {
+		return nil, ErrEveryPageBlank
+	}
+	pdfs := [][]byte{}
+	for _, p := range straightPages {
+		if p.blank {
+			continue
+		}
+		builder, err := d.newPDFBuilder()
+		if err != nil {
+			return nil, err
+		}
+		if err := builder.addPage(p); err != nil {
+			return nil, err
+		}
+		output := &bytes.Buffer{}
+		if err := d.writeContext(builder.ctx, output); err != nil {
+			return nil, err
+		}
+		pdfs = append(pdfs, output.Bytes())
+	}
+	return pdfs, nil
+}
+
+// Returns the source page index of each page that writePDF emits
+func outputPageMap(pages []straightenedPage) []int {
+	pageMap := make([]int, 0, len(pages))
+	for _, p := range pages {
+		if p.blank {
+			continue
+		}
+		pageMap = append(pageMap, p.page)
+	}
+	return pageMap
 }
 
-// Create a new PDF from the given images
-func (d *Document) buildNewPDF(images [][]byte) ([]byte, error) {
-	imageReaders := []io.Reader{}
-	for _, img := range images {
-		imageReaders = append(imageReaders, bytes.NewReader(img))
+// Given the list of page angles obtained by PageAngles(), write a straightened version of the document to w.
+// This avoids holding a second copy of the entire output PDF in memory.
+func (d *Document) StraightenToWriter(orient *textorient.Orient, pageAngles []float64, w io.Writer) error {
+	return d.StraightenToWriterContext(context.Background(), orient, pageAngles, w)
+}
+
+// StraightenToWriterContext is StraightenToWriter, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) StraightenToWriterContext(ctx context.Context, orient *textorient.Orient, pageAngles []float64, w io.Writer) error {
+	straightPages, err := d.straightenedImages(ctx, orient, d.allPages(), pageAngles)
+	if err != nil {
+		return err
 	}
+	return d.writePDF(w, straightPages)
+}
+
+// Create the output PDF from the given pages
+func (d *Document) buildPDF(pages []straightenedPage) ([]byte, error) {
+	pdf, _, err := d.buildPDFWithSizes(pages)
+	return pdf, err
+}
+
+// Same as buildPDF, but also return the size of each output page
+func (d *Document) buildPDFWithSizes(pages []straightenedPage) ([]byte, []types.Dim, error) {
 	output := &bytes.Buffer{}
-	importConfig := pdfcpu.DefaultImportConfig()
-	importConfig.Scale = 1
-	// types.Full is better than types.Center, because we get landscape/portrait pages, depending on the aspect ratio of the page.
-	// Basically, with types.Full, the page size matches the image size.
-	//importConfig.Pos = types.Center
-	importConfig.Pos = types.Full
-	if err := pdfapi.ImportImages(nil, output, imageReaders, importConfig, nil); err != nil {
+	sizes, err := d.writePDFWithSizes(output, pages)
+	if err != nil {
+		return nil, nil, err
+	}
+	return output.Bytes(), sizes, nil
+}
+
+// Same as buildPDF, but write the PDF to w
+func (d *Document) writePDF(w io.Writer, pages []straightenedPage) error {
+	_, err := d.writePDFWithSizes(w, pages)
+	return err
+}
+
+// Same as writePDF, but also return the size of each output page
+func (d *Document) writePDFWithSizes(w io.Writer, pages []straightenedPage) ([]types.Dim, error) {
+	if err := d.validatePDFOutput(); err != nil {
 		return nil, err
 	}
-	return output.Bytes(), nil
+	if d.RemoveBlankPages && countBlank(pages) == len(pages) {
+		return nil, ErrEveryPageBlank
+	}
+	if !d.ForceRebuild && d.isUnchangedDocument(pages) {
+		sizes, err := d.sourcePageSizes()
+		if err != nil {
+			return nil, err
+		}
+		return sizes, d.writeSourcePDF(w)
+	}
+	var ctx *model.Context
+	var err error
+	if d.PassThroughUnchanged && d.reader != nil && isWholeDocument(pages, d.NumPages) {
+		ctx, err = d.modifiedPDFContext(pages)
+	} else {
+		ctx, err = d.newPDFContext(pages)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := displayedPageSizes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sizes, d.writeContext(ctx, w)
 }
 
-// Return either the raw image (if angle == 0), or the straightened image
-func (d *Document) straightenImage(orient *textorient.Orient, raw []byte, img *cimg.Image, angle float64) ([]byte, error) {
-	fixed := img
-	if angle != 0 {
-		fixed = d.rotateImage(img, -angle)
+// Returns an error if the output format can't be placed in a PDF
+func (d *Document) validatePDFOutput() error {
+	if d.OutputFormat == FormatWebP {
+		return fmt.Errorf("WebP images can't be placed in a PDF, so FormatWebP is only available from StraightenedImages")
 	}
-	upright, err := orient.MakeUpright(fixed)
+	return nil
+}
+
+// Create a new PDF from the images of the given pages, discarding everything else in the source document
+func (d *Document) newPDFContext(pages []straightenedPage) (*model.Context, error) {
+	builder, err := d.newPDFBuilder()
 	if err != nil {
 		return nil, err
 	}
-	if upright == img {
-		// There was no transformation at all, so just return the original blob
-		return raw, nil
+	for _, p := range pages {
+		if err := builder.addPage(p); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.writePageLabels(builder.ctx, pages); err != nil {
+		return nil, err
+	}
+	return builder.ctx, nil
+}
+
+// Builds a new PDF from page images, one page at a time
+type pdfBuilder struct {
+	d           *Document
+	ctx         *model.Context
+	pagesIndRef *types.IndirectRef
+	pagesDict   types.Dict
+}
+
+func (d *Document) newPDFBuilder() (*pdfBuilder, error) {
+	// This is the body of pdfapi.ImportImages, but with a distinct import config for every page,
+	// because pages can have different physical sizes.
+	conf := d.pdfcpuConfig()
+	conf.Cmd = model.IMPORTIMAGES
+	ctx, err := pdfcpu.CreateContextWithXRefTable(conf, pdfcpu.DefaultImportConfig().PageDim)
+	if err != nil {
+		return nil, err
+	}
+	pagesIndRef, err := ctx.Pages()
+	if err != nil {
+		return nil, err
+	}
+	pagesDict, err := ctx.DereferenceDict(*pagesIndRef)
+	if err != nil {
+		return nil, err
+	}
+	return &pdfBuilder{d: d, ctx: ctx, pagesIndRef: pagesIndRef, pagesDict: pagesDict}, nil
+}
+
+// Append p to the document, unless it is blank
+func (b *pdfBuilder) addPage(p straightenedPage) error {
+	if p.blank {
+		return nil
+	}
+	var err error
+	if p.image == nil {
+		if p, err = b.d.placeholderPage(p.page); err != nil {
+			return err
+		}
+	}
+	xRefTable := b.ctx.XRefTable
+	indRef, err := pdfcpu.NewPageForImage(xRefTable, bytes.NewReader(p.image), b.pagesIndRef, b.d.pageImportConfig(p.image, p.dpi))
+	if err != nil {
+		return err
+	}
+	if err := packBilevelImage(xRefTable, indRef, p.image); err != nil {
+		return err
+	}
+	if err := addTextLayer(xRefTable, indRef, p); err != nil {
+		return err
+	}
+	if err := b.ctx.SetValid(*indRef); err != nil {
+		return err
+	}
+	if err := model.AppendPageTree(indRef, 1, b.pagesDict); err != nil {
+		return err
+	}
+	b.ctx.PageCount++
+	return nil
+}
+
+// Set the metadata of ctx, and write it to w
+func (d *Document) writeContext(ctx *model.Context, w io.Writer) error {
+	conf := ctx.Configuration
+	md, err := d.outputMetadata()
+	if err != nil {
+		return err
+	}
+	if err := setInfoDict(ctx, md); err != nil {
+		return err
+	}
+	if md.CreationDate == "" {
+		return pdfapi.Write(ctx, w, conf)
+	}
+	return writeWithCreationDate(ctx, md.CreationDate, w)
+}
+
+// Straighten the image of a page, and report the result
+func (d *Document) straightenPage(orient *textorient.Orient, page int, raw []byte, img *cimg.Image, angle float64) (straightenedPage, error) {
+	if override, ok := d.AngleOverrides[page]; ok {
+		angle = override
+		if math.IsNaN(override) {
+			angle = 0
+			orient = nil
+		}
+	}
+	blank := d.RemoveBlankPages && d.isBlankImage(img)
+	if blank {
+		// The angle and orientation of a blank page are meaningless
+		angle = 0
+		orient = nil
+	}
+	start := d.profileStart()
+	upright, result, err := d.transformImage(orient, page, img, angle)
+	if err != nil {
+		return straightenedPage{}, err
+	}
+	// The resolution of the page is measured against the source image, unless padding has made it page shaped
+	dpiImg := img
+	if d.PadToPageAspect {
+		if upright, err = d.padToPageAspect(page, upright); err != nil {
+			return straightenedPage{}, err
+		}
+		if upright != img {
+			dpiImg = upright
+		}
+	}
+	profile := PageProfile{Page: page, Transform: d.profileSince(start)}
+	start = d.profileStart()
+	fixed, err := d.encodeResult(raw, img, upright, &result)
+	if err != nil {
+		return straightenedPage{}, err
+	}
+	profile.Encode = d.profileSince(start)
+	var words []OCRWord
+	start = d.profileStart()
+	if d.OCR != nil && !blank {
+		if words, err = d.OCR.Recognize(upright); err != nil {
+			return straightenedPage{}, err
+		}
+	}
+	profile.OCR = d.profileSince(start)
+	result.Blank = blank
+	result.Page = page
+	d.reportResult(page, result)
+	dpi, err := d.sourceDPI(page, dpiImg)
+	return straightenedPage{
+		page:     page,
+		image:    fixed,
+		dpi:      dpi,
+		modified: result.Modified,
+		blank:    blank,
+		words:    words,
+		width:    upright.Width,
+		height:   upright.Height,
+		result:   result,
+		profile:  profile,
+	}, err
+}
+
+// Return either the raw image (if angle == 0), or the straightened image
+func (d *Document) straightenImage(orient *textorient.Orient, page int, raw []byte, img *cimg.Image, angle float64) ([]byte, PageResult, error) {
+	upright, result, err := d.transformImage(orient, page, img, angle)
+	if err != nil {
+		return nil, result, err
+	}
+	encoded, err := d.encodeResult(raw, img, upright, &result)
+	return encoded, result, err
+}
+
+// Encode upright, which is the result of transformImage on img, whose original blob is raw.
+// Sets result.Modified if the result is not raw.
+func (d *Document) encodeResult(raw []byte, img, upright *cimg.Image, result *PageResult) ([]byte, error) {
+	if upright == img && raw != nil {
+		// There was no transformation at all, so just return the original blob,
+		// unless it is not compatible with the requested output format.
+		if d.acceptsRawImage(raw) && !d.isAutoGrayscale(img) {
+			return raw, nil
+		}
+	}
+	result.Modified = true
+	return d.encodeImage(upright, raw)
+}
+
+// Rotate img by angle, make it upright (or the other way around, if OrientFirst is set), crop it if AutoCrop is set,
+// and filter it if Denoise or PostSharpen is set. Returns img itself if no transformation was needed.
+// page is only used to identify the images that are passed to DebugSink.
+func (d *Document) transformImage(orient *textorient.Orient, page int, img *cimg.Image, angle float64) (*cimg.Image, PageResult, error) {
+	deskewOnly := d.DeskewOnly || orient == nil
+	angle = d.correctionAngle(angle, deskewOnly)
+	result := PageResult{}
+	orientFirst := d.OrientFirst && !deskewOnly
+	src := img
+	if orientFirst {
+		// Rotations commute, so the skew that was measured on img is still the skew once img is upright
+		angle = skewOnly(angle)
+		var err error
+		if src, err = d.orientImage(orient, img, &result); err != nil {
+			return nil, result, err
+		}
+	}
+	result.Angle = angle
+	fixed := src
+	if angle != 0 && d.OrthogonalOnly {
+		fixed = rotateOrthogonal(src, -angle)
+	} else if angle != 0 {
+		fixed = d.rotateImage(src, -angle)
+		// Only the skew changes the profile, so a rotation by a multiple of 90 degrees is not measured
+		if d.MeasureDeskewQuality && skewOnly(angle) != 0 {
+			d.measureDeskewQuality(src, fixed, angle, &result)
+		}
+	}
+	d.debugImage(page, DebugStageRotated, fixed)
+	upright := fixed
+	if !deskewOnly && !orientFirst {
+		var err error
+		upright, err = d.orientImage(orient, fixed, &result)
+		if err != nil {
+			return nil, result, err
+		}
+		quarterTurns := int(math.Round((angle - skewOnly(angle)) / 90))
+		result.OrientationConflict = quarterTurns%2 != 0 && (result.Orientation == 90 || result.Orientation == 270)
+	}
+	if d.AutoCrop {
+		upright = autoCrop(upright, d.AutoCropPadding)
+	}
+	upright = d.postFilter(upright)
+	d.debugImage(page, DebugStageUpright, upright)
+	return upright, result, nil
+}
+
+// Returns the part of the detected angle that transformImage corrects, after DeskewOnly, OrthogonalOnly,
+// and MinCorrectAngleDegrees have had their say
+func (d *Document) correctionAngle(angle float64, deskewOnly bool) float64 {
+	if deskewOnly {
+		angle = skewOnly(angle)
+	}
+	if skew := skewOnly(angle); skew != 0 && (d.OrthogonalOnly || math.Abs(skew) < d.MinCorrectAngleDegrees) {
+		// Keep any multiple of 90 degrees, which is a real rotation, but ignore the skew
+		angle -= skew
+	}
+	return angle
+}
+
+// Remove any multiple of 90 degrees from angle, leaving just the skew, in the range [-45, 45)
+func skewOnly(angle float64) float64 {
+	return angle - 90*math.Floor((angle+45)/90)
+}
+
+func (d *Document) reportResult(page int, result PageResult) {
+	result.Page = page
+	if result.Flipped180 {
+		d.verbose("page %v: flipped 180 degrees\n", page+1)
+	}
+	if result.OrientationSuppressed {
+		d.verbose("page %v: orientation left alone (confidence %.2f)\n", page+1, result.OrientationConfidence)
+	} else if result.Orientation != 0 {
+		d.verbose("page %v: rotated %v degrees to make it upright\n", page+1, result.Orientation)
+	}
+	if result.OrientationConflict {
+		d.verbose("page %v: angle detection and orientation disagree about which way the text runs\n", page+1)
 	}
-	return cimg.Compress(upright, cimg.MakeCompressParams(cimg.Sampling444, 95, 0))
+	if result.Blank {
+		d.verbose("page %v: blank, removed\n", page+1)
+	}
+	if d.PageResultFunc != nil {
+		d.PageResultFunc(result)
+	}
+}
+
+func (d *Document) compressParams() cimg.CompressParams {
+	return cimg.MakeCompressParams(d.OutputSampling, d.OutputQuality, d.OutputFlags)
 }
 
 func (d *Document) rotateImage(img *cimg.Image, angle float64) *cimg.Image {
-	const cropLimitDegrees = 5
+	cropLimitDegrees := d.RotateExpandThresholdDegrees
 	var newWidth int
 	var newHeight int
+	if d.AlwaysExpand {
+		cropLimitDegrees = -1
+	}
 	if math.Abs(angle) <= cropLimitDegrees {
 		// If the angle is small, then just clip, because there's usually padding implicitly added by the rotated scan
 		newWidth = img.Width
@@ -234,7 +1248,12 @@ func (d *Document) rotateImage(img *cimg.Image, angle float64) *cimg.Image {
 	}
 
 	fixed := cimg.NewImage(newWidth, newHeight, img.Format)
+	// cimg only implements bilinear interpolation, which is smooth enough to avoid jagged character edges.
+	// Rotations by multiples of 90 degrees (see rotate90) move pixels, so they don't interpolate at all.
 	cimg.Rotate(img, fixed, angle*math.Pi/180, nil)
+	if d.RotateBackground != nil {
+		fillOutsideRotation(img.Width, img.Height, fixed, angle*math.Pi/180, d.RotateBackground)
+	}
 	return fixed
 	//compressed, err := cimg.Compress(fixed, cimg.MakeCompressParams(cimg.Sampling444, 95, 0))
 	//if err != nil {
@@ -244,51 +1263,218 @@ func (d *Document) rotateImage(img *cimg.Image, angle float64) *cimg.Image {
 	//fixed.WriteJPEG(fmt.Sprintf("fixed-%d.jpg", page), cimg.MakeCompressParams(cimg.Sampling444, 95, 0), 0644)
 }
 
-func (d *Document) getImageAngle(img *cimg.Image, maxAngle float64, include90Degrees bool) float64 {
-	getAngleParams := docangle.NewWhiteLinesParams()
-	getAngleParams.Include90Degrees = include90Degrees
-	getAngleParams.MinDeltaDegrees = -maxAngle
-	getAngleParams.MaxDeltaDegrees = maxAngle
-	_, angle := docangle.GetAngleWhiteLines(makeDocAngleImage(img), getAngleParams)
-	return angle
+// Returns the angle of the image, and the confidence of that angle.
+// page is only used to identify the images that are passed to DebugSink.
+func (d *Document) getImageAngle(page int, img *cimg.Image, maxAngle float64, include90Degrees bool) (float64, float64) {
+	detector := d.AngleDetector
+	if detector == nil {
+		detector = d.whiteLinesDetector()
+	}
+	angle, score := detector.DetectAngle(img, -maxAngle, maxAngle, include90Degrees)
+	// If the best angle is on the edge of the search range, then the true angle is probably beyond it
+	maxWiden := min(d.AutoWidenMaxDegrees, 45)
+	step := docangle.NewWhiteLinesParams().StepDegrees
+	for d.AutoWiden && maxAngle > 0 && maxAngle < maxWiden && math.Abs(skewOnly(angle)) >= maxAngle-step {
+		maxAngle = min(maxAngle*2, maxWiden)
+		d.verbose("angle %.1f is at the search limit, widening search to %.1f degrees\n", angle, maxAngle)
+		angle, score = detector.DetectAngle(img, -maxAngle, maxAngle, include90Degrees)
+	}
+	if d.FallbackDetector != nil && score <= d.FallbackMinConfidence {
+		fallbackAngle, fallbackScore := d.FallbackDetector.DetectAngle(img, -maxAngle, maxAngle, include90Degrees)
+		if fallbackScore > score {
+			d.verbose("angle confidence %.3f is low, using fallback angle %.2f (confidence %.3f)\n", score, fallbackAngle, fallbackScore)
+			detector, angle, score = d.FallbackDetector, fallbackAngle, fallbackScore
+		}
+	}
+	d.debugDetection(page, detector, img, angle)
+	if d.OrthogonalOnly {
+		angle -= skewOnly(angle)
+	}
+	return angle, score
 }
 
-// Returns raw image bytes, decompressed image, and error
+// Returns the default AngleDetector, configured by the Detect settings of the document
+func (d *Document) whiteLinesDetector() *WhiteLinesDetector {
+	return &WhiteLinesDetector{
+		MaxDimension:   d.DetectMaxDimension,
+		Binarize:       d.DetectBinarize,
+		BinarizeWindow: d.DetectBinarizeWindow,
+	}
+}
+
+// Returns the decoded image of a page, before straightening, as it would be fed to PageAngles and Straighten.
+// ApplyPageRotation, ApplyExifOrientation, ConcatenateImageStrips, CompositeImages and RenderFallback are honoured.
+// The image may be shared with the image cache, so it must not be modified.
+func (d *Document) PageImage(page int) (*cimg.Image, error) {
+	if err := d.validatePages([]int{page}); err != nil {
+		return nil, err
+	}
+	_, img, err := d.getCachedImageOnPage(page)
+	return img, err
+}
+
+// Returns raw image bytes, decompressed image, and error.
+// If the decompressed image is not a faithful copy of the raw image (eg because it was rotated
+// by the page's /Rotate attribute), then the raw image is nil.
 func (d *Document) getImageOnPage(pageIdx int) ([]byte, *cimg.Image, error) {
-	pageName := fmt.Sprintf("%d", pageIdx+1)
-	images, err := pdfapi.ExtractImagesRaw(d.reader, []string{pageName}, nil)
+	raw, img, err := d.getRawImageOnPage(pageIdx)
 	if err != nil {
 		return nil, nil, err
 	}
+	rotated := flattenAlpha(img)
+	// A nil raw image was rendered by go-fitz, which has already applied /Rotate
+	if raw == nil {
+		return nil, rotated, nil
+	}
+	if d.ApplyExifOrientation {
+		if rotated, err = applyExifOrientation(raw, rotated); err != nil {
+			return nil, nil, err
+		}
+	}
+	if d.ApplyPageRotation {
+		if rotated, err = d.applyPageRotation(pageIdx, rotated); err != nil {
+			return nil, nil, err
+		}
+	}
+	if rotated != img {
+		raw = nil
+	}
+	return raw, rotated, nil
+}
+
+// Returns raw image bytes, decompressed image, and error, without any /Rotate applied
+func (d *Document) getRawImageOnPage(pageIdx int) ([]byte, *cimg.Image, error) {
+	if d.tiff != nil {
+		if d.MaxDecodedPixels > 0 {
+			width, height, err := d.tiff.pageSize(pageIdx)
+			if err != nil {
+				return nil, nil, err
+			}
+			if width*height > d.MaxDecodedPixels {
+				return d.oversizedImage(pageIdx, width, height)
+			}
+		}
+		// TIFF is not an image format that we can embed in the output PDF, so there is no raw image
+		img, err := d.tiff.decodePage(pageIdx)
+		return nil, img, err
+	}
+	imageMap, err := d.extractPageImages(pageIdx)
+	if errors.Is(err, ErrUnexpectedImageCount) {
+		return nil, nil, err
+	} else if err != nil {
+		return d.renderUndecodableImageOnPage(pageIdx, err)
+	}
+	if d.ConcatenateImageStrips && isImageStrips(imageMap) {
+		return d.concatenateImageStrips(pageIdx, imageMap)
+	}
+	if len(imageMap) > 1 && d.CompositeImages {
+		return d.compositePageImages(pageIdx, imageMap)
+	}
+	if len(imageMap) == 0 {
+		return d.renderFallbackPage(pageIdx, newPageError(pageIdx, ErrNoImageOnPage))
+	}
+	raw, err := largestImage(imageMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	// This is a hidden failure mode of pdfcpu - doesn't happen often
+	// This is also how pdfcpu reports an image codec that it doesn't support, such as JBIG2
+	if raw == nil {
+		return d.renderUndecodableImageOnPage(pageIdx, newPageError(pageIdx, ErrNoImageOnPage))
+	}
+	if d.MaxDecodedPixels > 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(raw)); err == nil && cfg.Width*cfg.Height > d.MaxDecodedPixels {
+			return d.oversizedImage(pageIdx, cfg.Width, cfg.Height)
+		}
+	}
+	img, err := decodeImage(raw)
+	if err != nil {
+		return d.renderUndecodableImageOnPage(pageIdx, newPageError(pageIdx, err))
+	}
+	return raw, img, nil
+}
+
+// Returns the raw images of a PDF page, keyed by object number
+func (d *Document) extractPageImages(pageIdx int) (map[int]model.Image, error) {
+	pageName := fmt.Sprintf("%d", pageIdx+1)
+	var images []map[int]model.Image
+	err := d.withReader(func(r io.ReadSeeker) (err error) {
+		images, err = pdfapi.ExtractImagesRaw(r, []string{pageName}, d.pdfcpuConfig())
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
 	if len(images) != 1 {
-		return nil, nil, fmt.Errorf("ExtractImagesRaw returned an unexpected number of results (%v) on page %v", len(images), pageIdx+1)
+		return nil, newPageError(pageIdx, fmt.Errorf("%w (%v)", ErrUnexpectedImageCount, len(images)))
+	}
+	return images[0], nil
+}
+
+// Returns the encoded bytes of the image with the most pixels, or nil if none of the images could be extracted.
+// If a page has more than one image, the largest one is most likely the scan, and the others are
+// overlays such as stamps or logos. Ties are broken by the lowest object number, so that the choice
+// doesn't depend on map iteration order.
+func largestImage(images map[int]model.Image) ([]byte, error) {
+	objNrs := make([]int, 0, len(images))
+	for objNr := range images {
+		objNrs = append(objNrs, objNr)
 	}
-	imageMap := images[0]
-	for _, img := range imageMap {
-		// This is a hidden failure mode of pdfcpu - doesn't happen often
+	slices.Sort(objNrs)
+	var best []byte
+	bestPixels := -1
+	for _, objNr := range objNrs {
+		img := images[objNr]
 		if img.Reader == nil {
-			return nil, nil, fmt.Errorf("No image found on page %v", pageIdx+1)
+			continue
 		}
 		raw, err := io.ReadAll(img)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		img, err := cimg.Decompress(raw)
-		if err != nil {
-			return nil, nil, err
+		pixels := 0
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(raw)); err == nil {
+			pixels = cfg.Width * cfg.Height
+		}
+		if pixels > bestPixels {
+			best = raw
+			bestPixels = pixels
 		}
-		return raw, img, nil
 	}
-	return nil, nil, fmt.Errorf("No image found on page %v", pageIdx+1)
+	return best, nil
+}
+
+// Returns a copy of PDFConfiguration, or the default pdfcpu configuration if it is nil
+func (d *Document) pdfcpuConfig() *model.Configuration {
+	if d.PDFConfiguration == nil {
+		return model.NewDefaultConfiguration()
+	}
+	conf := *d.PDFConfiguration
+	return &conf
 }
 
 func (d *Document) verbose(format string, args ...interface{}) {
-	if d.Verbose {
+	if !d.Verbose {
+		return
+	}
+	if d.Logger != nil {
+		d.Logger.Printf(format, args...)
+	} else {
 		fmt.Printf(format, args...)
 	}
 }
 
-func makeDocAngleImage(img *cimg.Image) *docangle.Image {
+// Convert img to the grayscale image that docangle needs.
+// If maxDimension is not zero, the image is scaled down so that neither side exceeds maxDimension.
+// Because both axes are scaled by the same factor, the angles of the text lines are unchanged.
+func makeDocAngleImage(img *cimg.Image, maxDimension int) *docangle.Image {
+	if maxDimension > 0 && max(img.Width, img.Height) > maxDimension {
+		// Resize before converting to gray, so that we never make a full resolution copy
+		scale := float64(maxDimension) / float64(max(img.Width, img.Height))
+		w := max(1, int(math.Round(float64(img.Width)*scale)))
+		h := max(1, int(math.Round(float64(img.Height)*scale)))
+		img = cimg.ResizeNew(img, w, h, nil)
+	}
 	img = img.ToGray()
 	return &docangle.Image{
 		Pixels: img.Pixels,
@@ -0,0 +1,63 @@
+package pdfstraighten
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeAngles(t *testing.T) {
+	angles := []float64{0, 1.5, 85, 95, 80, 100, -85, 180}
+	if got := NormalizeAngles(angles, true); !slices.Equal(got, angles) {
+		t.Errorf("allow90Degrees: got %v, expected %v", got, angles)
+	}
+	want := []float64{0, 1.5, -5, 5, 80, 100, -85, 180}
+	if got := NormalizeAngles(angles, false); !slices.Equal(got, want) {
+		t.Errorf("got %v, expected %v", got, want)
+	}
+	if angles[2] != 85 {
+		t.Errorf("input was modified")
+	}
+}
+
+func TestSkewOnly(t *testing.T) {
+	cases := []struct {
+		angle float64
+		want  float64
+	}{
+		{0, 0},
+		{1.5, 1.5},
+		{-1.5, -1.5},
+		{44.9, 44.9},
+		{45, -45},
+		{-45, -45},
+		{91, 1},
+		{-91, -1},
+		{180, 0},
+		{270.5, 0.5},
+	}
+	for _, c := range cases {
+		if got := skewOnly(c.angle); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("skewOnly(%v) = %v, expected %v", c.angle, got, c.want)
+		}
+	}
+}
+
+func TestNewPageAngle(t *testing.T) {
+	cases := []struct {
+		angle        float64
+		skew         float64
+		quarterTurns int
+	}{
+		{0.5, 0.5, 0},
+		{90.5, 0.5, 1},
+		{-89, 1, -1},
+		{179, -1, 2},
+	}
+	for _, c := range cases {
+		a := newPageAngle(c.angle, 0.25)
+		if math.Abs(a.Skew-c.skew) > 1e-9 || a.QuarterTurns != c.quarterTurns || a.Angle != c.angle || a.Confidence != 0.25 {
+			t.Errorf("newPageAngle(%v) = %+v, expected skew %v and %v quarter turns", c.angle, a, c.skew, c.quarterTurns)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package pdfstraighten
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bmharper/textorient"
+)
+
+// StreamingStraightener builds a straightened PDF one page at a time, so that only one decoded page image
+// is in memory at once, instead of every page of the document. pdfcpu still holds the encoded page images
+// until Finish writes the PDF, but those are a small fraction of the size of the decoded images.
+// The output is always a new PDF, as if PassThroughUnchanged were false.
+type StreamingStraightener struct {
+	d       *Document
+	orient  *textorient.Orient
+	w       io.Writer
+	builder *pdfBuilder
+	next    int // Index of the next source page
+	blank   int // Number of pages dropped by RemoveBlankPages
+}
+
+// Returns a StreamingStraightener that writes to w. Call AddPage with the angle of each page, in order,
+// and then Finish. ProgressFunc and OnPageError apply to each AddPage, as they do to Straighten.
+func (d *Document) NewStreamingStraightener(orient *textorient.Orient, w io.Writer) (*StreamingStraightener, error) {
+	if err := d.validatePDFOutput(); err != nil {
+		return nil, err
+	}
+	builder, err := d.newPDFBuilder()
+	if err != nil {
+		return nil, err
+	}
+	return &StreamingStraightener{d: d, orient: orient, w: w, builder: builder}, nil
+}
+
+// Straighten the next page of the document by angle (as returned by PageAngles), and append it to the output
+func (s *StreamingStraightener) AddPage(angle float64) error {
+	d := s.d
+	page := s.next
+	if page >= d.NumPages {
+		return newPageError(page, ErrPageOutOfRange)
+	}
+	sp := straightenedPage{page: page}
+	fn := d.withProgress(d.withPageErrors(func(i, page int) error {
+		straight, err := withPageTimeout(d, page, func() (straightenedPage, error) {
+			raw, img, err := d.getCachedImageOnPage(page)
+			if err != nil {
+				return straightenedPage{}, err
+			}
+			return d.straightenPage(s.orient, page, raw, img, angle)
+		})
+		if err != nil {
+			return err
+		}
+		sp = straight
+		return nil
+	}), d.NumPages)
+	if err := fn(page, page); err != nil {
+		return err
+	}
+	s.next++
+	if sp.blank {
+		s.blank++
+	}
+	return s.builder.addPage(sp)
+}
+
+// Write the PDF of the pages that have been added
+func (s *StreamingStraightener) Finish() error {
+	if s.builder.ctx.PageCount == 0 {
+		if s.blank != 0 {
+			return ErrEveryPageBlank
+		}
+		return fmt.Errorf("No pages were added")
+	}
+	return s.d.writeContext(s.builder.ctx, s.w)
+}
@@ -0,0 +1,87 @@
+package pdfstraighten
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/bmharper/cimg/v2"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Returns true if the images on a page look like a scan that was split into horizontal strips,
+// which is to say that there is more than one image, and they are all the same width.
+func isImageStrips(images map[int]model.Image) bool {
+	if len(images) < 2 {
+		return false
+	}
+	width := -1
+	for _, img := range images {
+		if img.Width <= 0 || (width != -1 && img.Width != width) {
+			return false
+		}
+		width = img.Width
+	}
+	return true
+}
+
+// Decode the strips of a page, and stack them vertically into a single image.
+// Strips are stacked in object number order, which is the order in which scanners write them.
+// Like compositePageImages, the raw image is nil, because the image did not come directly from the PDF.
+func (d *Document) concatenateImageStrips(pageIdx int, images map[int]model.Image) ([]byte, *cimg.Image, error) {
+	objNrs := make([]int, 0, len(images))
+	totalHeight := 0
+	for objNr, img := range images {
+		objNrs = append(objNrs, objNr)
+		totalHeight += img.Height
+	}
+	slices.Sort(objNrs)
+	width := images[objNrs[0]].Width
+	if d.MaxDecodedPixels > 0 && width*totalHeight > d.MaxDecodedPixels {
+		return d.oversizedImage(pageIdx, width, totalHeight)
+	}
+
+	strips := make([]*cimg.Image, 0, len(objNrs))
+	for _, objNr := range objNrs {
+		img := images[objNr]
+		if img.Reader == nil {
+			return d.renderUndecodableImageOnPage(pageIdx, newPageError(pageIdx, ErrNoImageOnPage))
+		}
+		raw, err := io.ReadAll(img)
+		if err != nil {
+			return nil, nil, err
+		}
+		strip, err := decodeImage(raw)
+		if err != nil {
+			return d.renderUndecodableImageOnPage(pageIdx, newPageError(pageIdx, err))
+		}
+		strips = append(strips, flattenAlpha(strip))
+	}
+
+	// If the strips don't all have the same pixel format, then bring them all to RGB
+	format := strips[0].Format
+	height := 0
+	for _, s := range strips {
+		if s.Width != strips[0].Width {
+			return nil, nil, newPageError(pageIdx, fmt.Errorf("Image strips have different widths (%v and %v)", strips[0].Width, s.Width))
+		}
+		if s.Format != format {
+			format = cimg.PixelFormatRGB
+		}
+		height += s.Height
+	}
+
+	stacked := cimg.NewImage(strips[0].Width, height, format)
+	y := 0
+	for _, s := range strips {
+		if s.Format != format {
+			s = s.ToRGB()
+		}
+		if err := stacked.CopyImage(s, 0, y); err != nil {
+			return nil, nil, err
+		}
+		y += s.Height
+	}
+	d.verbose("page %v: concatenated %v image strips into %v x %v\n", pageIdx+1, len(strips), stacked.Width, stacked.Height)
+	return nil, stacked, nil
+}
@@ -0,0 +1,210 @@
+package pdfstraighten
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/bmharper/cimg/v2"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+	"golang.org/x/image/tiff"
+)
+
+// TIFF tags that we need in order to find and size the pages
+const (
+	tiffTagNewSubfileType = 254
+	tiffTagImageWidth     = 256
+	tiffTagImageLength    = 257
+	tiffTagXResolution    = 282
+	tiffTagYResolution    = 283
+	tiffTagResolutionUnit = 296
+)
+
+// A multi-page TIFF file, which is the source of a Document instead of a PDF
+type tiffSource struct {
+	data  []byte
+	order binary.ByteOrder
+	ifds  []uint32 // Offset of the IFD of each page
+}
+
+// Load a TIFF file, treating each page (IFD) of the file as a page of the document.
+// Reduced resolution images (eg thumbnails) are not pages. BigTIFF is not supported.
+// The entire file is read into memory. Straighten and friends produce a PDF, as they do for PDF input.
+// Page sizes are derived from the TIFF resolution tags. Pages without them are sized at one point per pixel.
+// Functions that need a PDF (eg PassThroughUnchanged, SourceMetadata) behave as if the source PDF were empty.
+func NewDocumentFromTIFF(r io.Reader) (*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	src, pages, err := parseTIFF(data)
+	if err != nil {
+		return nil, err
+	}
+	doc := newEmptyDocument()
+	doc.tiff = src
+	doc.NumPages = len(src.ifds)
+	doc.pageInfo = pages
+	return doc, nil
+}
+
+// Find the pages of a TIFF file
+func parseTIFF(data []byte) (*tiffSource, []pageInfo, error) {
+	if len(data) < 8 {
+		return nil, nil, fmt.Errorf("TIFF file is too short")
+	}
+	src := &tiffSource{data: data}
+	switch string(data[:4]) {
+	case "II*\x00":
+		src.order = binary.LittleEndian
+	case "MM\x00*":
+		src.order = binary.BigEndian
+	default:
+		return nil, nil, fmt.Errorf("Not a TIFF file, or an unsupported TIFF variant such as BigTIFF")
+	}
+	var pages []pageInfo
+	visited := map[uint32]bool{}
+	for offset := src.order.Uint32(data[4:8]); offset != 0; {
+		if visited[offset] {
+			return nil, nil, fmt.Errorf("TIFF IFD chain contains a loop")
+		}
+		visited[offset] = true
+		tags, next, err := src.readIFD(offset)
+		if err != nil {
+			return nil, nil, err
+		}
+		if tags[tiffTagNewSubfileType]&1 == 0 {
+			src.ifds = append(src.ifds, offset)
+			pages = append(pages, pageInfo{dim: tiffPageDim(tags)})
+		}
+		offset = next
+	}
+	if len(src.ifds) == 0 {
+		return nil, nil, fmt.Errorf("TIFF file has no pages")
+	}
+	return src, pages, nil
+}
+
+// Returns the value of the integer tags in an IFD, and the offset of the next IFD.
+// Rational tags are returned multiplied by 1000, which is all the precision we need for resolution.
+func (t *tiffSource) readIFD(offset uint32) (map[int]uint32, uint32, error) {
+	data := t.data
+	if int64(offset)+2 > int64(len(data)) {
+		return nil, 0, fmt.Errorf("TIFF IFD offset %v is out of bounds", offset)
+	}
+	count := int(t.order.Uint16(data[offset:]))
+	end := int64(offset) + 2 + int64(count)*12 + 4
+	if end > int64(len(data)) {
+		return nil, 0, fmt.Errorf("TIFF IFD at %v is truncated", offset)
+	}
+	tags := map[int]uint32{}
+	for i := range count {
+		entry := data[int(offset)+2+i*12:]
+		tag := int(t.order.Uint16(entry[0:]))
+		switch t.order.Uint16(entry[2:]) {
+		case 3: // SHORT
+			tags[tag] = uint32(t.order.Uint16(entry[8:]))
+		case 4: // LONG
+			tags[tag] = t.order.Uint32(entry[8:])
+		case 5: // RATIONAL, stored at an offset
+			at := int64(t.order.Uint32(entry[8:]))
+			if at+8 <= int64(len(data)) {
+				num := uint64(t.order.Uint32(data[at:]))
+				den := uint64(t.order.Uint32(data[at+4:]))
+				if den != 0 {
+					tags[tag] = uint32(num * 1000 / den)
+				}
+			}
+		}
+	}
+	return tags, t.order.Uint32(data[end-4:]), nil
+}
+
+// Returns the physical size of a TIFF page, or zero if it has no resolution
+func tiffPageDim(tags map[int]uint32) types.Dim {
+	xres := float64(tags[tiffTagXResolution]) / 1000
+	yres := float64(tags[tiffTagYResolution]) / 1000
+	unit, ok := tags[tiffTagResolutionUnit]
+	if !ok {
+		unit = 2 // The TIFF default is inches
+	}
+	switch unit {
+	case 2:
+	case 3: // Centimeters
+		xres *= 2.54
+		yres *= 2.54
+	default:
+		return types.Dim{}
+	}
+	if xres <= 0 || yres <= 0 {
+		return types.Dim{}
+	}
+	return types.Dim{
+		Width:  float64(tags[tiffTagImageWidth]) * 72 / xres,
+		Height: float64(tags[tiffTagImageLength]) * 72 / yres,
+	}
+}
+
+// The TIFF decoder only reads the first IFD, so for every other page we present it with a file
+// whose header points at the page's IFD instead.
+type tiffPageReader struct {
+	data   []byte
+	header [8]byte
+}
+
+func (r *tiffPageReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	if off < int64(len(r.header)) {
+		copy(p, r.header[off:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Returns a reader of the TIFF file, in which the first IFD is the given page
+func (t *tiffSource) pageReader(pageIdx int) io.Reader {
+	r := &tiffPageReader{data: t.data}
+	copy(r.header[:4], t.data[:4])
+	t.order.PutUint32(r.header[4:], t.ifds[pageIdx])
+	return io.NewSectionReader(r, 0, int64(len(t.data)))
+}
+
+// Returns the pixel dimensions of a page of the TIFF file, without decoding it
+func (t *tiffSource) pageSize(pageIdx int) (int, int, error) {
+	cfg, err := tiff.DecodeConfig(t.pageReader(pageIdx))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to decode TIFF page %v: %w", pageIdx+1, err)
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Decode a page of the TIFF file
+func (t *tiffSource) decodePage(pageIdx int) (*cimg.Image, error) {
+	decoded, err := tiff.Decode(t.pageReader(pageIdx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode TIFF page %v: %w", pageIdx+1, err)
+	}
+	return fromGoImage(decoded)
+}
+
+// Convert a page image that was decoded by the Go standard library to a cimg image
+func fromGoImage(decoded image.Image) (*cimg.Image, error) {
+	img, err := cimg.FromImage(decoded, true)
+	if err != nil {
+		// cimg only understands a few pixel layouts, so convert anything else (eg paletted, CMYK, 16-bit) to RGBA
+		rgba := image.NewRGBA(decoded.Bounds())
+		draw.Draw(rgba, rgba.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+		if img, err = cimg.FromImage(rgba, true); err != nil {
+			return nil, err
+		}
+	}
+	// Pages are opaque, so there's nothing to be gained from keeping the alpha channel
+	return flattenAlpha(img), nil
+}
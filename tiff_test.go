@@ -0,0 +1,138 @@
+package pdfstraighten
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// A tag of an IFD built by buildTIFF. Rational values are stored with a denominator of 1.
+type tiffTestTag struct {
+	tag   uint16
+	typ   uint16 // 3 (SHORT), 4 (LONG), or 5 (RATIONAL)
+	value uint32
+}
+
+// Returns a TIFF file with the given IFDs, and no image data
+func buildTIFF(order binary.ByteOrder, ifds [][]tiffTestTag) []byte {
+	// The header, then the IFDs, one after the other, then the values of the rational tags
+	offsets := make([]uint32, len(ifds))
+	size := uint32(8)
+	for i, tags := range ifds {
+		offsets[i] = size
+		size += 2 + 12*uint32(len(tags)) + 4
+	}
+	rationals := size
+	for _, tags := range ifds {
+		for _, tag := range tags {
+			if tag.typ == 5 {
+				size += 8
+			}
+		}
+	}
+	data := make([]byte, size)
+	if order == binary.LittleEndian {
+		copy(data, "II*\x00")
+	} else {
+		copy(data, "MM\x00*")
+	}
+	if len(ifds) != 0 {
+		order.PutUint32(data[4:], offsets[0])
+	}
+	for i, tags := range ifds {
+		ifd := data[offsets[i]:]
+		order.PutUint16(ifd, uint16(len(tags)))
+		for j, tag := range tags {
+			entry := ifd[2+12*j:]
+			order.PutUint16(entry[0:], tag.tag)
+			order.PutUint16(entry[2:], tag.typ)
+			order.PutUint32(entry[4:], 1)
+			switch tag.typ {
+			case 3:
+				order.PutUint16(entry[8:], uint16(tag.value))
+			case 4:
+				order.PutUint32(entry[8:], tag.value)
+			case 5:
+				order.PutUint32(entry[8:], rationals)
+				order.PutUint32(data[rationals:], tag.value)
+				order.PutUint32(data[rationals+4:], 1)
+				rationals += 8
+			}
+		}
+		if i+1 < len(ifds) {
+			order.PutUint32(ifd[2+12*len(tags):], offsets[i+1])
+		}
+	}
+	return data
+}
+
+func TestParseTIFF(t *testing.T) {
+	page := []tiffTestTag{
+		{tiffTagImageWidth, 3, 200},
+		{tiffTagImageLength, 4, 100},
+		{tiffTagXResolution, 5, 100},
+		{tiffTagYResolution, 5, 50},
+	}
+	thumbnail := []tiffTestTag{
+		{tiffTagNewSubfileType, 4, 1},
+		{tiffTagImageWidth, 3, 20},
+		{tiffTagImageLength, 3, 10},
+	}
+	centimeters := []tiffTestTag{
+		{tiffTagImageWidth, 4, 254},
+		{tiffTagImageLength, 4, 254},
+		{tiffTagXResolution, 5, 100},
+		{tiffTagYResolution, 5, 100},
+		{tiffTagResolutionUnit, 3, 3},
+	}
+	noResolution := []tiffTestTag{
+		{tiffTagImageWidth, 4, 300},
+		{tiffTagImageLength, 4, 300},
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data := buildTIFF(order, [][]tiffTestTag{page, thumbnail, centimeters, noResolution})
+		src, pages, err := parseTIFF(data)
+		if err != nil {
+			t.Errorf("%v: %v", order, err)
+			continue
+		}
+		if len(src.ifds) != 3 || len(pages) != 3 {
+			t.Errorf("%v: got %v pages, expected 3, because the thumbnail is not a page", order, len(src.ifds))
+			continue
+		}
+		want := [][2]float64{
+			{200 * 72 / 100.0, 100 * 72 / 50.0},
+			{254 * 72 / 254.0, 254 * 72 / 254.0},
+			{0, 0},
+		}
+		for i, p := range pages {
+			if math.Abs(p.dim.Width-want[i][0]) > 1e-6 || math.Abs(p.dim.Height-want[i][1]) > 1e-6 {
+				t.Errorf("%v: page %v is %v x %v points, expected %v x %v", order, i, p.dim.Width, p.dim.Height, want[i][0], want[i][1])
+			}
+		}
+	}
+}
+
+func TestParseTIFFRejectsBadFiles(t *testing.T) {
+	page := []tiffTestTag{{tiffTagImageWidth, 3, 1}, {tiffTagImageLength, 3, 1}}
+	loop := buildTIFF(binary.LittleEndian, [][]tiffTestTag{page})
+	// Point the next IFD offset of the only IFD back at itself
+	binary.LittleEndian.PutUint32(loop[8+2+12*len(page):], 8)
+	outOfBounds := buildTIFF(binary.LittleEndian, [][]tiffTestTag{page})
+	binary.LittleEndian.PutUint32(outOfBounds[4:], 1000)
+
+	cases := map[string][]byte{
+		"too short":       []byte("II*\x00"),
+		"not a TIFF":      []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00"),
+		"BigTIFF":         []byte("II+\x00\x08\x00\x00\x00\x10\x00\x00\x00\x00\x00\x00\x00"),
+		"IFD loop":        loop,
+		"IFD out of file": outOfBounds,
+		"truncated IFD":   buildTIFF(binary.LittleEndian, [][]tiffTestTag{page})[:16],
+		"only thumbnails": buildTIFF(binary.LittleEndian, [][]tiffTestTag{{{tiffTagNewSubfileType, 4, 1}}}),
+	}
+	for name, data := range cases {
+		if _, _, err := parseTIFF(data); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
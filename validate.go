@@ -0,0 +1,36 @@
+package pdfstraighten
+
+import (
+	"context"
+	"errors"
+)
+
+// Extract and decode the image of every page, without measuring or straightening anything, so that a
+// broken document can be rejected before committing to the expensive work. Returns one error per page,
+// which is nil if the page is fine, and otherwise a *PageError. The second return value is only for
+// failures that stop validation altogether, such as a cancelled context.
+// OnPageError is not consulted. If ImageCacheSize is set, the decoded images are cached for the passes that follow.
+func (d *Document) Validate() ([]error, error) {
+	return d.ValidateContext(context.Background())
+}
+
+// ValidateContext is Validate, but aborts with ctx.Err() if ctx is cancelled between pages.
+func (d *Document) ValidateContext(ctx context.Context) ([]error, error) {
+	pageErrors := make([]error, d.NumPages)
+	err := d.forEachPage(ctx, d.allPages(), func(i, page int) error {
+		_, err := withPageTimeout(d, page, func() (struct{}, error) {
+			_, _, err := d.getCachedImageOnPage(page)
+			return struct{}{}, err
+		})
+		var pageErr *PageError
+		if err != nil && !errors.As(err, &pageErr) {
+			err = newPageError(page, err)
+		}
+		pageErrors[i] = err
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pageErrors, nil
+}